@@ -0,0 +1,149 @@
+package poller
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	"github.com/onflow/flow-go-sdk"
+)
+
+// Ack confirms the event was processed. In AckMode, the checkpoint only advances past a height once
+// all events delivered for it are acked. It does nothing when AckMode is not enabled, and only the
+// first call to Ack or Nack for an event has an effect
+func (e *BlockEvent) Ack() {
+	e.ack.finish(false)
+}
+
+// Nack reports the event was not processed. In AckMode, polling resumes from the event's height on
+// the next cycle, so it's delivered again. Other events from the same heights are also delivered
+// again, to all subscriptions. It does nothing when AckMode is not enabled
+func (e *BlockEvent) Nack() {
+	e.ack.finish(true)
+}
+
+// eventAck tracks whether a delivered event was acked
+type eventAck struct {
+	tracker *ackTracker
+	height  uint64
+	once    sync.Once
+}
+
+// finish marks the event as acked or nacked. It's safe to call on a nil eventAck
+func (a *eventAck) finish(nack bool) {
+	if a == nil {
+		return
+	}
+	a.once.Do(func() {
+		a.tracker.finish(a.height, nack)
+	})
+}
+
+// release stops tracking an event that was not delivered, e.g. because it was dropped
+func (a *eventAck) release() {
+	a.finish(false)
+}
+
+// ackTracker counts the events delivered at each height that are waiting for an ack, and the lowest
+// height with a nacked event
+type ackTracker struct {
+	mu      sync.Mutex
+	pending map[uint64]int
+	nacked  uint64
+}
+
+// track records an event delivered at height, and returns its ack
+func (t *ackTracker) track(height uint64) *eventAck {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.pending == nil {
+		t.pending = make(map[uint64]int)
+	}
+	t.pending[height]++
+
+	return &eventAck{tracker: t, height: height}
+}
+
+func (t *ackTracker) finish(height uint64, nack bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.pending[height]--
+	if t.pending[height] <= 0 {
+		delete(t.pending, height)
+	}
+
+	if nack && (t.nacked == 0 || height < t.nacked) {
+		t.nacked = height
+	}
+}
+
+// lowestHeight returns the highest height up to height that can be checkpointed, which is before
+// the lowest height with events waiting for an ack or nacked
+func (t *ackTracker) lowestHeight(height uint64) uint64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	lowest := height
+	if t.nacked > 0 && t.nacked-1 < lowest {
+		lowest = t.nacked - 1
+	}
+	for pending := range t.pending {
+		if pending > 0 && pending-1 < lowest {
+			lowest = pending - 1
+		}
+	}
+	return lowest
+}
+
+// nackedHeight returns the lowest height with a nacked event, or 0 if there are none
+func (t *ackTracker) nackedHeight() uint64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.nacked
+}
+
+// clearNacked forgets nacked events at or after height, once they're being delivered again
+func (t *ackTracker) clearNacked(height uint64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.nacked >= height {
+		t.nacked = 0
+	}
+}
+
+// rewindNacked returns the header to resume polling after so nacked events are delivered again. It
+// returns lastHeader if there are no nacked events
+func (p *EventPoller) rewindNacked(ctx context.Context, lastHeader *flow.BlockHeader) (*flow.BlockHeader, error) {
+	if !p.AckMode {
+		return lastHeader, nil
+	}
+
+	height := p.acks.nackedHeight()
+	if height == 0 || height > lastHeader.Height {
+		return lastHeader, nil
+	}
+
+	header, err := p.client.GetBlockHeaderByHeight(ctx, height-1)
+	if err != nil {
+		return nil, fmt.Errorf("error getting header for height %d: %w", height-1, err)
+	}
+
+	p.log.Infof("redelivering events from height %d after nack", height)
+	p.acks.clearNacked(height)
+
+	if p.dedup != nil {
+		p.dedup.forget(height)
+	}
+	p.schedule.rewind(header.Height)
+	for _, sub := range p.allSubscribers() {
+		if sub.Watermark() > header.Height {
+			atomic.StoreUint64(&sub.watermark, header.Height)
+		}
+	}
+
+	return header, nil
+}
@@ -0,0 +1,32 @@
+package poller
+
+// WithEventTypeAliases registers short names for event types, e.g.
+// "FlowToken.TokensWithdrawn" for "A.1654653399040a61.FlowToken.TokensWithdrawn". Aliases can be
+// used in place of the full event type anywhere the poller accepts event types, and are replaced
+// with the full type before querying. Events are delivered with their full type.
+func WithEventTypeAliases(aliases map[string]string) Option {
+	return func(p *EventPoller) {
+		if p.aliases == nil {
+			p.aliases = make(map[string]string, len(aliases))
+		}
+		for alias, eventType := range aliases {
+			p.aliases[alias] = eventType
+		}
+	}
+}
+
+// resolveAliases returns events with any aliases replaced by their full event types
+func (p *EventPoller) resolveAliases(events []string) []string {
+	if len(p.aliases) == 0 {
+		return events
+	}
+
+	resolved := make([]string, len(events))
+	for i, event := range events {
+		if eventType, ok := p.aliases[event]; ok {
+			event = eventType
+		}
+		resolved[i] = event
+	}
+	return resolved
+}
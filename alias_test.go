@@ -0,0 +1,58 @@
+package poller
+
+import (
+	"reflect"
+	"testing"
+)
+
+const testAlias = "Test.Event"
+
+func TestUnsubscribeWithAlias(t *testing.T) {
+	p := newTestPoller(newFakeClient(10), WithEventTypeAliases(map[string]string{testAlias: testEvent}))
+
+	sub := p.Subscribe([]string{testAlias})
+	if got := sub.Events; !reflect.DeepEqual(got, []string{testEvent}) {
+		t.Fatalf("expected the subscription's events to be [%s], got %v", testEvent, got)
+	}
+
+	p.Unsubscribe(sub.ID, []string{testAlias})
+
+	if p.hasSubscriptions() {
+		t.Fatal("expected the subscription to be removed")
+	}
+	select {
+	case <-sub.done:
+	default:
+		t.Fatal("expected the subscription to be closed")
+	}
+}
+
+func TestSubscribeVersionedWithAlias(t *testing.T) {
+	const newEvent = "A.0000000000000002.Test.Event"
+
+	c := newFakeClient(10)
+	for height := uint64(1); height <= 10; height++ {
+		c.addEvent(testEvent, height)
+		c.addEvent(newEvent, height)
+	}
+
+	p := newTestPoller(c, WithEventTypeAliases(map[string]string{testAlias: testEvent}))
+	sub := p.SubscribeVersioned([]VersionedType{
+		{Type: testAlias, FromHeight: 1, ToHeight: 5},
+		{Type: newEvent, FromHeight: 6},
+	}, WithBufferSize(10))
+	poll(t, p, 0)
+
+	got := make(map[string][]uint64)
+	for _, event := range receive(sub) {
+		got[event.Event.Type] = append(got[event.Event.Type], event.BlockHeight)
+	}
+
+	expected := map[string][]uint64{
+		testEvent: {1, 2, 3, 4, 5},
+		newEvent:  {6, 7, 8, 9, 10},
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Fatalf("expected events %v, got %v", expected, got)
+	}
+}
@@ -0,0 +1,27 @@
+package poller
+
+// allEvents is the key used for subscriptions to all events
+const allEvents = "*"
+
+// SubscribeAll creates a subscription for every event emitted on chain, regardless of type.
+//
+// The access API can only query events by type, so while any SubscribeAll subscription exists, the
+// poller fetches the events of types without their own subscriptions by walking every block,
+// collection and transaction in each range. This requires several requests per block, so expect
+// much higher load on the access node, and slower catch up. Events emitted by the system chunk
+// (e.g. epoch events) are only included for event types that other subscriptions are subscribed to,
+// which are still queried by type.
+func (p *EventPoller) SubscribeAll(opts ...SubscribeOption) *Subscription {
+	sub := newSubscription([]string{allEvents}, opts)
+	sub.Channel = make(chan *BlockEvent, sub.bufferSize)
+
+	return p.subscribe(sub)
+}
+
+// hasAllSubscription returns true if any subscription receives all events
+func (p *EventPoller) hasAllSubscription() bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	return len(p.subscriptions[allEvents]) > 0
+}
@@ -0,0 +1,72 @@
+package poller
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSubscribeAllKeepsQueryingSubscribedTypes(t *testing.T) {
+	const epochEvent = "flow.EpochSetup"
+	const otherEvent = "A.0000000000000001.Test.Other"
+
+	c := newFakeClient(5)
+	c.systemEvents = map[string]bool{epochEvent: true}
+	c.addEvent(epochEvent, 2)
+	c.addEvent(testEvent, 3)
+	c.addEvent(otherEvent, 3)
+	c.addEvent(otherEvent, 4)
+
+	p := newTestPoller(c)
+	epochSub := p.Subscribe([]string{epochEvent}, WithBufferSize(10))
+	testSub := p.Subscribe([]string{testEvent}, WithBufferSize(10))
+	allSub := p.SubscribeAll(WithBufferSize(10))
+	poll(t, p, 0)
+
+	// events from the system chunk are only returned by event queries
+	if got := heights(receive(epochSub)); !reflect.DeepEqual(got, []uint64{2}) {
+		t.Fatalf("expected %s events at heights [2], got %v", epochEvent, got)
+	}
+	if got := heights(receive(testSub)); !reflect.DeepEqual(got, []uint64{3}) {
+		t.Fatalf("expected %s events at heights [3], got %v", testEvent, got)
+	}
+
+	counts := make(map[string][]uint64)
+	for _, event := range receive(allSub) {
+		counts[event.Event.Type] = append(counts[event.Event.Type], event.BlockHeight)
+	}
+	expected := map[string][]uint64{
+		epochEvent: {2},
+		testEvent:  {3},
+		otherEvent: {3, 4},
+	}
+	if !reflect.DeepEqual(counts, expected) {
+		t.Fatalf("expected the subscription to all events to receive %v, got %v", expected, counts)
+	}
+
+	queried := make(map[string]bool)
+	for _, query := range c.eventQueries() {
+		queried[query.Type] = true
+	}
+	if !reflect.DeepEqual(queried, map[string]bool{epochEvent: true, testEvent: true}) {
+		t.Fatalf("expected queries for the subscribed event types only, got %v", queried)
+	}
+}
+
+func TestSubscribeAllOrderedDelivery(t *testing.T) {
+	const otherEvent = "A.0000000000000001.Test.Other"
+
+	c := newFakeClient(5)
+	c.addEvent(otherEvent, 2)
+	c.addEvent(testEvent, 3)
+	c.addEvent(otherEvent, 4)
+
+	p := newTestPoller(c)
+	p.OrderedDelivery = true
+	p.Subscribe([]string{testEvent}, WithBufferSize(10))
+	allSub := p.SubscribeAll(WithBufferSize(10))
+	poll(t, p, 0)
+
+	if got := heights(receive(allSub)); !reflect.DeepEqual(got, []uint64{2, 3, 4}) {
+		t.Fatalf("expected events at heights [2 3 4], got %v", got)
+	}
+}
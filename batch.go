@@ -0,0 +1,327 @@
+package poller
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/onflow/flow-go-sdk"
+	"github.com/onflow/flow-go-sdk/client"
+)
+
+// BlockEventBatch contains all events within a single block that match a subscription
+type BlockEventBatch struct {
+	BlockID        flow.Identifier
+	BlockHeight    uint64
+	BlockTimestamp time.Time
+
+	// Events are ordered by transaction index, then event index
+	Events []flow.Event
+
+	// Reorg is set when the batch is a reorg notification instead of a block's events. All other
+	// fields are empty for reorg notifications
+	Reorg *Reorg
+}
+
+// SubscribeBatches creates a subscription for a list of events that delivers all matching events
+// for a block at once. Batches are sent on the Subscription's Batches channel, in ascending block
+// order. Blocks without any matching events are skipped.
+func (p *EventPoller) SubscribeBatches(events []string, opts ...SubscribeOption) *Subscription {
+	sub := newSubscription(events, opts)
+	sub.Batches = make(chan *BlockEventBatch, sub.bufferSize)
+
+	return p.subscribe(sub)
+}
+
+// SubscribeBlockEvents creates a subscription for a list of events that delivers all matching
+// events for a block as the SDK's client.BlockEvents, on the Subscription's BlockEvents channel.
+// This is equivalent to SubscribeBatches, for consumers that already handle the SDK's types. Reorg
+// notifications are not sent to these subscriptions since client.BlockEvents can't represent them.
+func (p *EventPoller) SubscribeBlockEvents(events []string, opts ...SubscribeOption) *Subscription {
+	sub := newSubscription(events, opts)
+	sub.BlockEvents = make(chan *client.BlockEvents, sub.bufferSize)
+
+	return p.subscribe(sub)
+}
+
+// batchCollector accumulates events for subscriptions that receive events grouped by block or
+// transaction while a height range is polled, since events for a single block are fetched
+// separately for each event type. When ordered, it also accumulates all polled events so they can
+// be delivered in block order once the range is polled. It also counts the polled events by type,
+// and the events delivered to subscriptions.
+type batchCollector struct {
+	mu        sync.Mutex
+	batches   map[*Subscription]map[uint64]*BlockEventBatch
+	ordered   map[uint64]*client.BlockEvents
+	counts    map[string]uint64
+	delivered int
+
+	// routes maps event types returned by queries for a different type to the queried type
+	routes map[string]string
+
+	// maxHeld limits the ordered events held, by shrinking the range to end at the last block that
+	// fits. The range never ends before minEnd. Blocks after shrunkTo are dropped, and shrunkTo is 0
+	// if the range isn't shrunk
+	maxHeld  int
+	minEnd   uint64
+	shrunkTo uint64
+}
+
+func newBatchCollector(ordered bool) *batchCollector {
+	c := &batchCollector{
+		batches: make(map[*Subscription]map[uint64]*BlockEventBatch),
+		counts:  make(map[string]uint64),
+	}
+	if ordered {
+		c.ordered = make(map[uint64]*client.BlockEvents)
+	}
+	return c
+}
+
+func (c *batchCollector) addBlockEvents(blockEvents []client.BlockEvents) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, be := range blockEvents {
+		if c.shrunkTo > 0 && be.Height > c.shrunkTo {
+			continue
+		}
+
+		block, ok := c.ordered[be.Height]
+		if !ok {
+			block = &client.BlockEvents{
+				BlockID:        be.BlockID,
+				Height:         be.Height,
+				BlockTimestamp: be.BlockTimestamp,
+			}
+			c.ordered[be.Height] = block
+		}
+
+		block.Events = append(block.Events, be.Events...)
+	}
+
+	if c.maxHeld > 0 {
+		c.shrink()
+	}
+}
+
+// limitHeld shrinks the range whenever more than max ordered events are held, so it ends at the last
+// block that fits. The range never ends before minEnd, so it always makes progress
+func (c *batchCollector) limitHeld(max int, minEnd uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.maxHeld = max
+	c.minEnd = minEnd
+}
+
+// shrink ends the range at the last block that fits within maxHeld events, and drops the blocks
+// after it. It must be called with the lock held
+func (c *batchCollector) shrink() {
+	heights := make([]uint64, 0, len(c.ordered))
+	held := 0
+	for height, block := range c.ordered {
+		heights = append(heights, height)
+		held += len(block.Events)
+	}
+	if held <= c.maxHeld {
+		return
+	}
+
+	sort.Slice(heights, func(i, j int) bool {
+		return heights[i] < heights[j]
+	})
+
+	end := c.minEnd
+	held = 0
+	for _, height := range heights {
+		held += len(c.ordered[height].Events)
+		if held > c.maxHeld {
+			break
+		}
+		if height > end {
+			end = height
+		}
+	}
+
+	if c.shrunkTo > 0 && end >= c.shrunkTo {
+		return
+	}
+
+	c.shrunkTo = end
+	for height := range c.ordered {
+		if height > end {
+			delete(c.ordered, height)
+		}
+	}
+}
+
+// endHeight returns the end of the range, which is height unless the range was shrunk
+func (c *batchCollector) endHeight(height uint64) uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.shrunkTo > 0 && c.shrunkTo < height {
+		return c.shrunkTo
+	}
+	return height
+}
+
+// route records that events of returnedType were returned by a query for queriedType
+func (c *batchCollector) route(returnedType, queriedType string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.routes == nil {
+		c.routes = make(map[string]string)
+	}
+	c.routes[returnedType] = queriedType
+}
+
+// routeType returns the queried type for events of eventType returned by a query for a different
+// type, or "" if there's none
+func (c *batchCollector) routeType(eventType string) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.routes[eventType]
+}
+
+// count records the number of polled events by type
+func (c *batchCollector) count(blockEvents []client.BlockEvents) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, be := range blockEvents {
+		for _, event := range be.Events {
+			c.counts[event.Type]++
+		}
+	}
+}
+
+// recount counts the polled events by type again, only including the blocks up to height. It's
+// used when only part of the range is processed, and requires the events to be ordered
+func (c *batchCollector) recount(height uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.counts = make(map[string]uint64)
+	for _, block := range c.ordered {
+		if block.Height > height {
+			continue
+		}
+		for _, event := range block.Events {
+			c.counts[event.Type]++
+		}
+	}
+}
+
+// addDelivered records that n events were delivered to subscriptions
+func (c *batchCollector) addDelivered(n int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.delivered += n
+}
+
+// deliveredCount returns the number of events delivered to subscriptions
+func (c *batchCollector) deliveredCount() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.delivered
+}
+
+// orderedEvents returns the accumulated events sorted by block height, transaction index and
+// event index
+func (c *batchCollector) orderedEvents() []client.BlockEvents {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	blockEvents := make([]client.BlockEvents, 0, len(c.ordered))
+	for _, block := range c.ordered {
+		sortEvents(block.Events)
+		blockEvents = append(blockEvents, *block)
+	}
+
+	sort.Slice(blockEvents, func(i, j int) bool {
+		return blockEvents[i].Height < blockEvents[j].Height
+	})
+
+	return blockEvents
+}
+
+func (c *batchCollector) add(sub *Subscription, be client.BlockEvents, event flow.Event) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	blocks, ok := c.batches[sub]
+	if !ok {
+		blocks = make(map[uint64]*BlockEventBatch)
+		c.batches[sub] = blocks
+	}
+
+	batch, ok := blocks[be.Height]
+	if !ok {
+		batch = &BlockEventBatch{
+			BlockID:        be.BlockID,
+			BlockHeight:    be.Height,
+			BlockTimestamp: be.BlockTimestamp,
+		}
+		blocks[be.Height] = batch
+	}
+
+	batch.Events = append(batch.Events, event)
+}
+
+// deliverBatches sends the collected batches to their subscriptions in ascending block order
+func (p *EventPoller) deliverBatches(ctx context.Context, c *batchCollector) {
+	for sub, blocks := range c.batches {
+		batches := make([]*BlockEventBatch, 0, len(blocks))
+		for _, batch := range blocks {
+			sortEvents(batch.Events)
+			batches = append(batches, batch)
+		}
+
+		sort.Slice(batches, func(i, j int) bool {
+			return batches[i].BlockHeight < batches[j].BlockHeight
+		})
+
+		for _, batch := range batches {
+			if sub.Transactions != nil {
+				for _, tx := range splitTransactions(batch) {
+					if !p.deliverTransaction(ctx, sub, tx) {
+						return
+					}
+				}
+				continue
+			}
+
+			if sub.BlockEvents != nil {
+				be := &client.BlockEvents{
+					BlockID:        batch.BlockID,
+					Height:         batch.BlockHeight,
+					BlockTimestamp: batch.BlockTimestamp,
+					Events:         batch.Events,
+				}
+				if !p.deliverBlockEvents(ctx, sub, be) {
+					return
+				}
+				continue
+			}
+
+			if !p.deliverBatch(ctx, sub, batch) {
+				return
+			}
+		}
+	}
+}
+
+// sortEvents sorts events within a block by transaction index, then event index
+func sortEvents(events []flow.Event) {
+	sort.Slice(events, func(i, j int) bool {
+		if events[i].TransactionIndex != events[j].TransactionIndex {
+			return events[i].TransactionIndex < events[j].TransactionIndex
+		}
+		return events[i].EventIndex < events[j].EventIndex
+	})
+}
@@ -0,0 +1,45 @@
+package poller
+
+import (
+	"sync"
+)
+
+// eventBuffer tracks the number of events fetched but not yet delivered, so new fetches can be
+// paused while slow subscribers catch up
+type eventBuffer struct {
+	mu    sync.Mutex
+	cond  *sync.Cond
+	count int
+}
+
+func newEventBuffer() *eventBuffer {
+	b := &eventBuffer{}
+	b.cond = sync.NewCond(&b.mu)
+	return b
+}
+
+// wait blocks until fewer than max events are buffered. It never blocks when no events are buffered,
+// so a single response larger than max can still be fetched
+func (b *eventBuffer) wait(max int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for b.count > 0 && b.count >= max {
+		b.cond.Wait()
+	}
+}
+
+func (b *eventBuffer) add(n int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.count += n
+}
+
+func (b *eventBuffer) release(n int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.count -= n
+	b.cond.Broadcast()
+}
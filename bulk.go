@@ -0,0 +1,114 @@
+package poller
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/onflow/flow-go-sdk"
+	"github.com/onflow/flow-go-sdk/client"
+)
+
+// pollBulk fetches all events within a height range, and delivers the ones that have subscribers.
+// Events of the skipped types are left out, since they're queried by type. It returns the last
+// height fetched, which is before startHeight if the first block failed
+func (p *EventPoller) pollBulk(ctx, deliverCtx context.Context, startHeight uint64, header *flow.BlockHeader, batches *batchCollector, skip map[string]bool) (uint64, error) {
+	if startHeight > batches.endHeight(header.Height) {
+		return header.Height, nil
+	}
+
+	for height := startHeight; ; height++ {
+		be, err := p.fetchBlockEvents(ctx, height)
+		if err != nil {
+			return height - 1, err
+		}
+
+		events := be.Events[:0]
+		for _, event := range be.Events {
+			if !skip[event.Type] {
+				events = append(events, event)
+			}
+		}
+		be.Events = events
+
+		blockEvents := []client.BlockEvents{*be}
+		batches.count(blockEvents)
+
+		if batches.ordered != nil {
+			batches.addBlockEvents(blockEvents)
+		} else {
+			p.deliverEvents(deliverCtx, blockEvents, batches)
+		}
+
+		// avoid overflowing when the range ends at the max height. The range may also have been
+		// shrunk to limit the events held
+		if height >= batches.endHeight(header.Height) {
+			return height, nil
+		}
+	}
+}
+
+// DiscoverEventTypes returns the distinct event types emitted within the height range from start to
+// end, inclusive, in the order they were first seen. This is useful to find the event types to
+// subscribe to. It walks every block, collection and transaction in the range, so it's slow for
+// large ranges. Events emitted by the system chunk (e.g. epoch events) are not included.
+func (p *EventPoller) DiscoverEventTypes(ctx context.Context, start, end uint64) ([]string, error) {
+	if start > end {
+		return nil, fmt.Errorf("invalid height range: start height %d is after end height %d", start, end)
+	}
+
+	seen := make(map[string]bool)
+	var types []string
+	for height := start; height <= end; height++ {
+		be, err := p.fetchBlockEvents(ctx, height)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, event := range be.Events {
+			if !seen[event.Type] {
+				seen[event.Type] = true
+				types = append(types, event.Type)
+			}
+		}
+
+		// avoid overflowing when end is the max height
+		if height == end {
+			break
+		}
+	}
+
+	return types, nil
+}
+
+// fetchBlockEvents returns all events emitted by transactions within the block at height. Events
+// emitted by the system chunk are not included since its transaction is not part of a collection.
+func (p *EventPoller) fetchBlockEvents(ctx context.Context, height uint64) (*client.BlockEvents, error) {
+	block, err := p.client.GetBlockByHeight(ctx, height)
+	if err != nil {
+		return nil, fmt.Errorf("error getting block for height %d: %w", height, err)
+	}
+
+	be := &client.BlockEvents{
+		BlockID:        block.ID,
+		Height:         block.Height,
+		BlockTimestamp: block.Timestamp,
+	}
+
+	for _, guarantee := range block.CollectionGuarantees {
+		collection, err := p.client.GetCollection(ctx, guarantee.CollectionID)
+		if err != nil {
+			return nil, fmt.Errorf("error getting collection %s: %w", guarantee.CollectionID, err)
+		}
+
+		for _, txID := range collection.TransactionIDs {
+			result, err := p.client.GetTransactionResult(ctx, txID)
+			if err != nil {
+				return nil, fmt.Errorf("error getting transaction result %s: %w", txID, err)
+			}
+
+			be.Events = append(be.Events, result.Events...)
+		}
+	}
+
+	return be, nil
+}
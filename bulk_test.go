@@ -0,0 +1,39 @@
+package poller
+
+import (
+	"math"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestPollBulkNearMaxHeight(t *testing.T) {
+	const otherEvent = "A.0000000000000001.Test.Other"
+
+	c := newFakeClient(math.MaxUint64)
+	c.addEvent(testEvent, math.MaxUint64-1)
+	c.addEvent(otherEvent, math.MaxUint64)
+
+	p := newTestPoller(c)
+	p.Subscribe([]string{testEvent}, WithBufferSize(10))
+	allSub := p.SubscribeAll(WithBufferSize(10))
+
+	done := make(chan uint64)
+	go func() {
+		done <- poll(t, p, math.MaxUint64-2)
+	}()
+
+	select {
+	case height := <-done:
+		if height != math.MaxUint64 {
+			t.Fatalf("expected to poll up to the max height, got %d", height)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("polling did not stop at the max height")
+	}
+
+	expected := []uint64{math.MaxUint64 - 1, math.MaxUint64}
+	if got := heights(receive(allSub)); !reflect.DeepEqual(got, expected) {
+		t.Fatalf("expected events at heights %v, got %v", expected, got)
+	}
+}
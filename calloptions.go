@@ -0,0 +1,69 @@
+package poller
+
+import (
+	"context"
+
+	"github.com/onflow/flow-go-sdk"
+	"github.com/onflow/flow-go-sdk/client"
+	"google.golang.org/grpc"
+)
+
+// WithCallOptions adds gRPC call options to each request to the access node, e.g. to set the max
+// message size or compression. Options passed to WithCallOptions are applied before the options set
+// by the poller for individual requests.
+func WithCallOptions(opts ...grpc.CallOption) Option {
+	return func(p *EventPoller) {
+		p.client = &callOptionsClient{
+			Client: p.client,
+			opts:   opts,
+		}
+	}
+}
+
+// WithMaxRecvMsgSize sets the max size in bytes of responses from the access node. Ranges with many
+// events can exceed gRPC's default limit of 4MB.
+func WithMaxRecvMsgSize(n int) Option {
+	return WithCallOptions(grpc.MaxCallRecvMsgSize(n))
+}
+
+// callOptionsClient adds call options to each request
+type callOptionsClient struct {
+	Client
+	opts []grpc.CallOption
+}
+
+func (c *callOptionsClient) callOptions(opts []grpc.CallOption) []grpc.CallOption {
+	return append(append([]grpc.CallOption{}, c.opts...), opts...)
+}
+
+func (c *callOptionsClient) GetLatestBlockHeader(ctx context.Context, isSealed bool, opts ...grpc.CallOption) (*flow.BlockHeader, error) {
+	return c.Client.GetLatestBlockHeader(ctx, isSealed, c.callOptions(opts)...)
+}
+
+func (c *callOptionsClient) GetBlockHeaderByHeight(ctx context.Context, height uint64, opts ...grpc.CallOption) (*flow.BlockHeader, error) {
+	return c.Client.GetBlockHeaderByHeight(ctx, height, c.callOptions(opts)...)
+}
+
+func (c *callOptionsClient) GetBlockHeaderByID(ctx context.Context, blockID flow.Identifier, opts ...grpc.CallOption) (*flow.BlockHeader, error) {
+	return c.Client.GetBlockHeaderByID(ctx, blockID, c.callOptions(opts)...)
+}
+
+func (c *callOptionsClient) GetEventsForHeightRange(ctx context.Context, query client.EventRangeQuery, opts ...grpc.CallOption) ([]client.BlockEvents, error) {
+	return c.Client.GetEventsForHeightRange(ctx, query, c.callOptions(opts)...)
+}
+
+func (c *callOptionsClient) GetAccountAtLatestBlock(ctx context.Context, address flow.Address, opts ...grpc.CallOption) (*flow.Account, error) {
+	return c.Client.GetAccountAtLatestBlock(ctx, address, c.callOptions(opts)...)
+}
+
+func (c *callOptionsClient) GetBlockByHeight(ctx context.Context, height uint64, opts ...grpc.CallOption) (*flow.Block, error) {
+	return c.Client.GetBlockByHeight(ctx, height, c.callOptions(opts)...)
+}
+
+func (c *callOptionsClient) GetCollection(ctx context.Context, colID flow.Identifier, opts ...grpc.CallOption) (*flow.Collection, error) {
+	return c.Client.GetCollection(ctx, colID, c.callOptions(opts)...)
+}
+
+func (c *callOptionsClient) GetTransactionResult(ctx context.Context, txID flow.Identifier, opts ...grpc.CallOption) (*flow.TransactionResult, error) {
+	return c.Client.GetTransactionResult(ctx, txID, c.callOptions(opts)...)
+}
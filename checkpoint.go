@@ -0,0 +1,104 @@
+package poller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Checkpointer persists the last processed height so the poller can resume where it left off after
+// a restart
+type Checkpointer interface {
+	// Load returns the last saved height. A height of 0 means no checkpoint has been saved
+	Load(ctx context.Context) (uint64, error)
+
+	// Save persists the last processed height
+	Save(ctx context.Context, height uint64) error
+}
+
+// FileCheckpointer is a Checkpointer that stores the last processed height in a file
+type FileCheckpointer struct {
+	path string
+}
+
+func NewFileCheckpointer(path string) *FileCheckpointer {
+	return &FileCheckpointer{
+		path: path,
+	}
+}
+
+// Load reads the height from the checkpoint file. If the file does not exist, 0 is returned
+func (c *FileCheckpointer) Load(_ context.Context) (uint64, error) {
+	data, err := os.ReadFile(c.path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return 0, nil
+		}
+		return 0, fmt.Errorf("error reading checkpoint file: %w", err)
+	}
+
+	height, err := strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("error parsing checkpoint file: %w", err)
+	}
+
+	return height, nil
+}
+
+// Save writes the height to the checkpoint file. The file is written to a temp file first, then
+// renamed so a crash mid-write doesn't corrupt the checkpoint
+func (c *FileCheckpointer) Save(_ context.Context, height uint64) error {
+	tmp, err := os.CreateTemp(filepath.Dir(c.path), filepath.Base(c.path)+".tmp")
+	if err != nil {
+		return fmt.Errorf("error creating checkpoint file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.WriteString(strconv.FormatUint(height, 10)); err != nil {
+		tmp.Close()
+		return fmt.Errorf("error writing checkpoint file: %w", err)
+	}
+
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("error writing checkpoint file: %w", err)
+	}
+
+	if err := os.Rename(tmp.Name(), c.path); err != nil {
+		return fmt.Errorf("error saving checkpoint file: %w", err)
+	}
+
+	return nil
+}
+
+// MemoryCheckpointer is a Checkpointer that keeps the last processed height in memory. It's mostly
+// useful for tests
+type MemoryCheckpointer struct {
+	mu     sync.Mutex
+	height uint64
+}
+
+func NewMemoryCheckpointer(height uint64) *MemoryCheckpointer {
+	return &MemoryCheckpointer{
+		height: height,
+	}
+}
+
+func (c *MemoryCheckpointer) Load(_ context.Context) (uint64, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.height, nil
+}
+
+func (c *MemoryCheckpointer) Save(_ context.Context, height uint64) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.height = height
+	return nil
+}
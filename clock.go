@@ -0,0 +1,31 @@
+package poller
+
+import "time"
+
+// Clock provides the current time and timers to the poller. It can be replaced in tests to control
+// polling intervals and backoffs without waiting for real time to pass
+type Clock interface {
+	// Now returns the current time
+	Now() time.Time
+
+	// After returns a channel that receives the current time once d has elapsed
+	After(d time.Duration) <-chan time.Time
+}
+
+// RealClock is a Clock that uses the time package
+type RealClock struct{}
+
+func (RealClock) Now() time.Time {
+	return time.Now()
+}
+
+func (RealClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+// WithClock configures the Clock used by the poller. Defaults to RealClock
+func WithClock(clock Clock) Option {
+	return func(p *EventPoller) {
+		p.clock = clock
+	}
+}
@@ -8,7 +8,6 @@ import (
 	"syscall"
 	"time"
 
-	"github.com/onflow/flow-go-sdk"
 	"github.com/onflow/flow-go-sdk/client"
 	poller "github.com/peterargue/flow-event-poller"
 	"google.golang.org/grpc"
@@ -20,8 +19,9 @@ const (
 )
 
 var events = []string{
-	"A.1654653399040a61.FlowToken.TokensWithdrawn",
-	"A.1654653399040a61.FlowToken.TokensDeposited",
+	poller.FlowTokenEvent(poller.Mainnet, "TokensWithdrawn"),
+	poller.FlowTokenEvent(poller.Mainnet, "TokensDeposited"),
+	"flow.AccountCreated",
 }
 
 func main() {
@@ -44,11 +44,15 @@ func main() {
 		log.Fatalf("error running event poller: %v", err)
 	}
 
+	if err := p.Close(); err != nil {
+		log.Printf("error closing event poller: %v", err)
+	}
+
 	log.Println("Shutting down...")
 }
 
-func eventHandler(event *flow.Event) {
-	log.Printf("Tx : %s => %s : %s", event.TransactionID, event.Type, event.ID())
+func eventHandler(event *poller.BlockEvent) {
+	log.Printf("event: %s", event)
 }
 
 func eventLoop(ctx context.Context, ch <-chan *poller.BlockEvent) {
@@ -56,8 +60,11 @@ func eventLoop(ctx context.Context, ch <-chan *poller.BlockEvent) {
 		select {
 		case <-ctx.Done():
 			return
-		case e := <-ch:
-			eventHandler(e.Event)
+		case e, ok := <-ch:
+			if !ok {
+				return
+			}
+			eventHandler(e)
 		}
 	}
 }
@@ -0,0 +1,206 @@
+package poller
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+)
+
+// DefaultAPIKeyHeader is the request header used to send the API key if none is configured
+const DefaultAPIKeyHeader = "x-api-key"
+
+// AccessConfig configures the connection to an access node
+type AccessConfig struct {
+	// URL is the address of the access node's gRPC API, e.g. access.mainnet.nodes.onflow.org:9000
+	URL string
+
+	// TLS enables transport security for the connection. It's implied when any of the other TLS
+	// settings are set
+	TLS bool
+
+	// TransportCredentials sets the transport credentials used for the connection, overriding TLS
+	// and the other TLS settings
+	TransportCredentials credentials.TransportCredentials
+
+	// TLSConfig sets the TLS configuration used for the connection. CACertFile, CertFile, KeyFile
+	// and PinnedCertificates are added to a copy of it
+	TLSConfig *tls.Config
+
+	// CACertFile is the path to a PEM encoded CA certificate used to verify the access node's
+	// certificate instead of the system roots, e.g. for a private access node
+	CACertFile string
+
+	// CertFile and KeyFile are the paths to a PEM encoded client certificate and key, used for mutual
+	// TLS
+	CertFile string
+	KeyFile  string
+
+	// PinnedCertificates are the hex encoded SHA-256 fingerprints of the DER encoded certificates the
+	// access node may present. When set, connections fail unless the node's certificate matches one
+	// of them
+	PinnedCertificates []string
+
+	// APIKey is sent as a header with every request when set. This is required by some hosted
+	// access node providers
+	APIKey string
+
+	// APIKeyHeader sets the name of the header used to send APIKey. Defaults to DefaultAPIKeyHeader
+	APIKeyHeader string
+
+	// DialOptions are additional options used when dialing the access node
+	DialOptions []grpc.DialOption
+}
+
+// NewEventPollerWithConfig creates an EventPoller with a client connected to the access node
+// described by config. The connection is dialed lazily on first use, and reconnects in the
+// background when the node becomes unavailable. If requests keep failing with Unavailable, the node
+// is redialed with the same url and dial options, with a backoff between redials, so the poller
+// recovers when the node restarts. The client is closed when the poller is closed.
+func NewEventPollerWithConfig(config AccessConfig, interval time.Duration, opts ...Option) (*EventPoller, error) {
+	if config.URL == "" {
+		return nil, fmt.Errorf("access node url is required")
+	}
+
+	dialOpts, err := config.dialOptions()
+	if err != nil {
+		return nil, err
+	}
+
+	pool := newNodePool([]string{config.URL}, dialOpts)
+	p := newEventPoller(pool, interval)
+	p.ownedClient = pool
+	for _, opt := range opts {
+		opt(p)
+	}
+
+	return p, nil
+}
+
+func (c AccessConfig) dialOptions() ([]grpc.DialOption, error) {
+	var opts []grpc.DialOption
+	secure := true
+	switch {
+	case c.TransportCredentials != nil:
+		opts = append(opts, grpc.WithTransportCredentials(c.TransportCredentials))
+	case c.TLS || c.TLSConfig != nil || c.CACertFile != "" || c.CertFile != "" || c.KeyFile != "" || len(c.PinnedCertificates) > 0:
+		tlsConfig, err := c.tlsConfig()
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, grpc.WithTransportCredentials(credentials.NewTLS(tlsConfig)))
+	default:
+		secure = false
+		opts = append(opts, grpc.WithInsecure())
+	}
+
+	if c.APIKey != "" {
+		header := c.APIKeyHeader
+		if header == "" {
+			header = DefaultAPIKeyHeader
+		}
+
+		opts = append(opts, grpc.WithPerRPCCredentials(apiKeyCredentials{
+			header: header,
+			key:    c.APIKey,
+			secure: secure,
+		}))
+	}
+
+	return append(opts, c.DialOptions...), nil
+}
+
+// tlsConfig builds the TLS configuration from TLSConfig, CACertFile, CertFile, KeyFile and
+// PinnedCertificates
+func (c AccessConfig) tlsConfig() (*tls.Config, error) {
+	config := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+	}
+	if c.TLSConfig != nil {
+		config = c.TLSConfig.Clone()
+	}
+
+	if c.CACertFile != "" {
+		pem, err := os.ReadFile(c.CACertFile)
+		if err != nil {
+			return nil, fmt.Errorf("error reading CA certificate: %w", err)
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", c.CACertFile)
+		}
+		config.RootCAs = pool
+	}
+
+	if c.CertFile != "" || c.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("error loading client certificate: %w", err)
+		}
+		config.Certificates = append(config.Certificates, cert)
+	}
+
+	if len(c.PinnedCertificates) > 0 {
+		pins := make([][]byte, 0, len(c.PinnedCertificates))
+		for _, pin := range c.PinnedCertificates {
+			fingerprint, err := hex.DecodeString(strings.ReplaceAll(pin, ":", ""))
+			if err != nil || len(fingerprint) != sha256.Size {
+				return nil, fmt.Errorf("invalid pinned certificate fingerprint %q", pin)
+			}
+			pins = append(pins, fingerprint)
+		}
+
+		config.VerifyPeerCertificate = verifyPinnedCertificate(pins, config.VerifyPeerCertificate)
+	}
+
+	return config, nil
+}
+
+// verifyPinnedCertificate returns a tls.Config.VerifyPeerCertificate function that requires the
+// peer's leaf certificate to match one of the pinned fingerprints, in addition to any existing
+// verification
+func verifyPinnedCertificate(pins [][]byte, next func([][]byte, [][]*x509.Certificate) error) func([][]byte, [][]*x509.Certificate) error {
+	return func(rawCerts [][]byte, chains [][]*x509.Certificate) error {
+		if len(rawCerts) == 0 {
+			return fmt.Errorf("access node presented no certificate")
+		}
+
+		fingerprint := sha256.Sum256(rawCerts[0])
+		for _, pin := range pins {
+			if bytes.Equal(fingerprint[:], pin) {
+				if next != nil {
+					return next(rawCerts, chains)
+				}
+				return nil
+			}
+		}
+		return fmt.Errorf("access node certificate %x does not match any pinned certificate", fingerprint)
+	}
+}
+
+// apiKeyCredentials adds an API key header to every request
+type apiKeyCredentials struct {
+	header string
+	key    string
+	secure bool
+}
+
+func (c apiKeyCredentials) GetRequestMetadata(context.Context, ...string) (map[string]string, error) {
+	return map[string]string{
+		c.header: c.key,
+	}, nil
+}
+
+func (c apiKeyCredentials) RequireTransportSecurity() bool {
+	return c.secure
+}
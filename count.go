@@ -0,0 +1,40 @@
+package poller
+
+import (
+	"context"
+	"fmt"
+)
+
+// CountEvents returns the number of events of each type within the height range from start to end,
+// inclusive, without delivering them to any subscriptions. This is useful to estimate the volume of
+// events before subscribing. The range is queried in chunks of MaxHeightRange.
+func (p *EventPoller) CountEvents(ctx context.Context, events []string, start, end uint64) (map[string]uint64, error) {
+	if start > end {
+		return nil, fmt.Errorf("invalid height range: start height %d is after end height %d", start, end)
+	}
+
+	events = p.resolveAliases(events)
+	counts := make(map[string]uint64, len(events))
+	for _, eventType := range uniqueEvents(events) {
+		counts[eventType] = 0
+	}
+
+	err := p.forEachChunk(start, end, func(chunkStart, chunkEnd uint64) error {
+		for _, eventType := range uniqueEvents(events) {
+			blockEvents, err := p.getEvents(ctx, eventType, chunkStart, chunkEnd)
+			if err != nil {
+				return err
+			}
+
+			for _, be := range blockEvents {
+				counts[eventType] += uint64(len(be.Events))
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return counts, nil
+}
@@ -0,0 +1,95 @@
+package poller
+
+import (
+	"sync"
+
+	"github.com/onflow/flow-go-sdk"
+)
+
+// WithDedup configures the poller to skip events it has already delivered within the last
+// windowBlocks blocks. Events can be delivered more than once when a height range is processed
+// again after an error. This is best effort: events older than the window are forgotten, and the
+// cache is kept in memory so it does not survive restarts.
+func WithDedup(windowBlocks uint64) Option {
+	return func(p *EventPoller) {
+		p.dedup = newDedupCache(windowBlocks)
+	}
+}
+
+type eventKey struct {
+	transactionID flow.Identifier
+	eventIndex    int
+}
+
+// dedupCache tracks the events delivered within a sliding window of heights
+type dedupCache struct {
+	mu         sync.Mutex
+	window     uint64
+	seen       map[eventKey]uint64
+	maxHeight  uint64
+	lastPruned uint64
+}
+
+func newDedupCache(window uint64) *dedupCache {
+	return &dedupCache{
+		window: window,
+		seen:   make(map[eventKey]uint64),
+	}
+}
+
+// seenBefore records an event, and returns true if it was already recorded
+func (c *dedupCache) seenBefore(height uint64, event *flow.Event) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	key := eventKey{
+		transactionID: event.TransactionID,
+		eventIndex:    event.EventIndex,
+	}
+
+	if _, ok := c.seen[key]; ok {
+		return true
+	}
+	c.seen[key] = height
+
+	if height > c.maxHeight {
+		c.maxHeight = height
+		c.prune()
+	}
+
+	return false
+}
+
+// forget removes all events at or above height, so they are delivered again. This is used when
+// blocks are orphaned, since their transactions may be included again in the new blocks
+func (c *dedupCache) forget(height uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for key, seenHeight := range c.seen {
+		if seenHeight >= height {
+			delete(c.seen, key)
+		}
+	}
+}
+
+// prune removes events that fell out of the window. Pruning requires scanning all events, so it's
+// only done after the window has moved by a quarter of its size
+func (c *dedupCache) prune() {
+	step := c.window / 4
+	if step == 0 {
+		step = 1
+	}
+
+	if c.maxHeight < c.lastPruned+step || c.maxHeight <= c.window {
+		return
+	}
+	c.lastPruned = c.maxHeight
+
+	oldest := c.maxHeight - c.window
+	for key, height := range c.seen {
+		if height < oldest {
+			delete(c.seen, key)
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package poller
+
+import (
+	"fmt"
+)
+
+// errorBufferSize is the buffer size of each subscription's error channel. Errors are dropped if
+// the buffer is full, so consumers that don't read errors never block the poller
+const errorBufferSize = 16
+
+// SubscriptionError describes a failure that may have prevented events from being delivered to a
+// subscription
+type SubscriptionError struct {
+	// EventType is the event type that failed to poll. It's empty if the failure was not specific
+	// to an event type, e.g. when the latest block header could not be fetched
+	EventType string
+
+	// StartHeight and EndHeight are the inclusive height range affected by the failure. They are 0
+	// when the range is not known
+	StartHeight uint64
+	EndHeight   uint64
+
+	// SubscriptionIDs are the IDs of the subscriptions to the event type that failed to poll, which
+	// may have missed events in the range
+	SubscriptionIDs []string
+
+	// Terminal is true when the poller stopped because of the failure, and no more events will be
+	// delivered
+	Terminal bool
+
+	Err error
+}
+
+func (e *SubscriptionError) Error() string {
+	if e.EventType == "" {
+		return fmt.Sprintf("error polling events for %d - %d: %v", e.StartHeight, e.EndHeight, e.Err)
+	}
+	return fmt.Sprintf("error polling events %s for %d - %d: %v", e.EventType, e.StartHeight, e.EndHeight, e.Err)
+}
+
+func (e *SubscriptionError) Unwrap() error {
+	return e.Err
+}
+
+// subscriptionIDs returns the IDs of the subscriptions
+func subscriptionIDs(subs []*Subscription) []string {
+	ids := make([]string, 0, len(subs))
+	for _, sub := range subs {
+		ids = append(ids, sub.ID)
+	}
+	return ids
+}
+
+// notifyError sends an error to the error channels of the provided subscriptions without blocking
+func (p *EventPoller) notifyError(subs []*Subscription, subErr *SubscriptionError) {
+	for _, sub := range subs {
+		sub.mu.RLock()
+		if !sub.closed {
+			select {
+			case sub.errors <- subErr:
+			default:
+			}
+		}
+		sub.mu.RUnlock()
+	}
+}
@@ -0,0 +1,431 @@
+package poller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/onflow/flow-go-sdk"
+	"github.com/onflow/flow-go-sdk/client"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// minNodeBackoff is the time a failed node is skipped after its first failure
+	minNodeBackoff = time.Second
+
+	// maxNodeBackoff is the maximum time a failed node is skipped
+	maxNodeBackoff = time.Minute
+
+	// redialAfterFailures is the number of consecutive Unavailable errors from a node after which its
+	// connections are replaced with new ones
+	redialAfterFailures = 3
+)
+
+// NewEventPollerWithNodes creates an EventPoller that connects to multiple access nodes, and fails
+// over to the next node when a request fails with a transient error. Connections are dialed lazily
+// on first use.
+func NewEventPollerWithNodes(urls []string, dialOpts []grpc.DialOption, interval time.Duration, opts ...Option) (*EventPoller, error) {
+	if len(urls) == 0 {
+		return nil, fmt.Errorf("at least one access node url is required")
+	}
+
+	pool := newNodePool(urls, dialOpts)
+	p := newEventPoller(pool, interval)
+	p.ownedClient = pool
+	for _, opt := range opts {
+		opt(p)
+	}
+
+	return p, nil
+}
+
+// nodeClient is a connection to an access node
+type nodeClient interface {
+	Client
+	Close() error
+}
+
+var _ nodeClient = (*client.Client)(nil)
+
+// dialClient connects to the access node at url
+func dialClient(url string, opts ...grpc.DialOption) (nodeClient, error) {
+	return client.New(url, opts...)
+}
+
+// nodeConn is a connection to an access node, and the number of requests using it
+type nodeConn struct {
+	client   nodeClient
+	inFlight int
+
+	// retired is set once the connection is replaced by a redial. It's closed once the requests
+	// using it return
+	retired bool
+}
+
+type accessNode struct {
+	index    int
+	url      string
+	conns    []*nodeConn
+	next     int
+	failures int
+	retryAt  time.Time
+
+	// unavailable is the number of consecutive Unavailable errors, which triggers a redial once it
+	// reaches redialAfterFailures. redials is the number of redials since the last success, which
+	// sets the backoff until the next redial at redialAt
+	unavailable int
+	redials     int
+	redialAt    time.Time
+}
+
+// nodePool implements Client on top of a list of access nodes. Requests are sent to the current
+// node until it fails with a transient error, then the same request is retried on the next node.
+// Failed nodes are skipped with an exponential backoff. Their connections are kept open, since other
+// requests may still be using them, and gRPC reconnects them in the background if they're broken.
+// When a node keeps failing with Unavailable, e.g. because its connection is stuck after a restart,
+// it's redialed with the same url and dial options, with a backoff between redials. The replaced
+// connections are closed once the requests using them return.
+type nodePool struct {
+	mu       sync.Mutex
+	nodes    []*accessNode
+	current  int
+	dialOpts []grpc.DialOption
+
+	// dial connects to an access node. Defaults to dialClient
+	dial func(url string, opts ...grpc.DialOption) (nodeClient, error)
+
+	// connsPerNode is the number of connections opened to each node. Requests are distributed
+	// across them round-robin
+	connsPerNode int
+}
+
+var _ Client = (*nodePool)(nil)
+
+func newNodePool(urls []string, dialOpts []grpc.DialOption) *nodePool {
+	nodes := make([]*accessNode, len(urls))
+	for i, url := range urls {
+		nodes[i] = &accessNode{
+			index: i,
+			url:   url,
+		}
+	}
+
+	return &nodePool{
+		nodes:        nodes,
+		dialOpts:     dialOpts,
+		dial:         dialClient,
+		connsPerNode: 1,
+	}
+}
+
+func (np *nodePool) GetLatestBlockHeader(ctx context.Context, isSealed bool, opts ...grpc.CallOption) (*flow.BlockHeader, error) {
+	var header *flow.BlockHeader
+	err := np.do(ctx, func(c Client) (err error) {
+		header, err = c.GetLatestBlockHeader(ctx, isSealed, opts...)
+		return err
+	})
+	return header, err
+}
+
+func (np *nodePool) GetBlockHeaderByHeight(ctx context.Context, height uint64, opts ...grpc.CallOption) (*flow.BlockHeader, error) {
+	var header *flow.BlockHeader
+	err := np.do(ctx, func(c Client) (err error) {
+		header, err = c.GetBlockHeaderByHeight(ctx, height, opts...)
+		return err
+	})
+	return header, err
+}
+
+func (np *nodePool) GetBlockHeaderByID(ctx context.Context, blockID flow.Identifier, opts ...grpc.CallOption) (*flow.BlockHeader, error) {
+	var header *flow.BlockHeader
+	err := np.do(ctx, func(c Client) (err error) {
+		header, err = c.GetBlockHeaderByID(ctx, blockID, opts...)
+		return err
+	})
+	return header, err
+}
+
+func (np *nodePool) GetEventsForHeightRange(ctx context.Context, query client.EventRangeQuery, opts ...grpc.CallOption) ([]client.BlockEvents, error) {
+	var blockEvents []client.BlockEvents
+	err := np.do(ctx, func(c Client) (err error) {
+		blockEvents, err = c.GetEventsForHeightRange(ctx, query, opts...)
+		return err
+	})
+	return blockEvents, err
+}
+
+func (np *nodePool) GetAccountAtLatestBlock(ctx context.Context, address flow.Address, opts ...grpc.CallOption) (*flow.Account, error) {
+	var account *flow.Account
+	err := np.do(ctx, func(c Client) (err error) {
+		account, err = c.GetAccountAtLatestBlock(ctx, address, opts...)
+		return err
+	})
+	return account, err
+}
+
+func (np *nodePool) GetBlockByHeight(ctx context.Context, height uint64, opts ...grpc.CallOption) (*flow.Block, error) {
+	var block *flow.Block
+	err := np.do(ctx, func(c Client) (err error) {
+		block, err = c.GetBlockByHeight(ctx, height, opts...)
+		return err
+	})
+	return block, err
+}
+
+func (np *nodePool) GetCollection(ctx context.Context, colID flow.Identifier, opts ...grpc.CallOption) (*flow.Collection, error) {
+	var collection *flow.Collection
+	err := np.do(ctx, func(c Client) (err error) {
+		collection, err = c.GetCollection(ctx, colID, opts...)
+		return err
+	})
+	return collection, err
+}
+
+func (np *nodePool) GetTransactionResult(ctx context.Context, txID flow.Identifier, opts ...grpc.CallOption) (*flow.TransactionResult, error) {
+	var result *flow.TransactionResult
+	err := np.do(ctx, func(c Client) (err error) {
+		result, err = c.GetTransactionResult(ctx, txID, opts...)
+		return err
+	})
+	return result, err
+}
+
+// Close closes the connections to all nodes
+func (np *nodePool) Close() error {
+	np.mu.Lock()
+	defer np.mu.Unlock()
+
+	var err error
+	for _, node := range np.nodes {
+		if closeErr := node.close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+	}
+
+	return err
+}
+
+// do runs fn against the current node, failing over to the next node on transient errors. Each
+// node is tried at most once per call. Failover stops once ctx is done, since a deadline set by the
+// caller, e.g. with WithRequestTimeout, applies to the call as a whole and would expire on the next
+// node too.
+func (np *nodePool) do(ctx context.Context, fn func(c Client) error) error {
+	var err error
+	for attempt := 0; attempt < len(np.nodes); attempt++ {
+		var node *accessNode
+		var conn *nodeConn
+
+		node, conn, err = np.acquire()
+		if err != nil {
+			np.markFailed(node, err)
+			continue
+		}
+
+		err = fn(conn.client)
+		np.release(conn)
+		if err == nil {
+			np.markHealthy(node)
+			return nil
+		}
+
+		if !isTransientError(err) {
+			return err
+		}
+
+		np.markFailed(node, err)
+		if ctx.Err() != nil {
+			return err
+		}
+	}
+
+	return err
+}
+
+// acquire returns the node and connection to use for the next request, dialing the node if it's not
+// connected, or redialing it if it keeps failing with Unavailable. Nodes that are backing off are
+// skipped, unless all nodes are backing off, in which case the one that will recover first is used.
+// The connection must be released once the request returns.
+func (np *nodePool) acquire() (*accessNode, *nodeConn, error) {
+	np.mu.Lock()
+	defer np.mu.Unlock()
+
+	now := time.Now()
+	var node *accessNode
+	for i := 0; i < len(np.nodes); i++ {
+		candidate := np.nodes[(np.current+i)%len(np.nodes)]
+		if !candidate.retryAt.After(now) {
+			node = candidate
+			break
+		}
+
+		if node == nil || candidate.retryAt.Before(node.retryAt) {
+			node = candidate
+		}
+	}
+	np.current = node.index
+
+	if len(node.conns) > 0 && node.unavailable >= redialAfterFailures && !node.redialAt.After(now) {
+		np.redial(node, now)
+	}
+
+	if len(node.conns) == 0 {
+		conns, err := np.connect(node)
+		if err != nil {
+			return node, nil, err
+		}
+		node.conns = conns
+	}
+
+	conn := node.conns[node.next%len(node.conns)]
+	node.next++
+	conn.inFlight++
+
+	return node, conn, nil
+}
+
+// connect opens connsPerNode connections to the node. The pool's lock must be held
+func (np *nodePool) connect(node *accessNode) ([]*nodeConn, error) {
+	conns := make([]*nodeConn, 0, np.connsPerNode)
+	for i := 0; i < np.connsPerNode; i++ {
+		c, err := np.dial(node.url, np.dialOpts...)
+		if err != nil {
+			for _, conn := range conns {
+				_ = conn.client.Close()
+			}
+			return nil, fmt.Errorf("error connecting to access node %s: %w", node.url, err)
+		}
+		conns = append(conns, &nodeConn{client: c})
+	}
+	return conns, nil
+}
+
+// redial replaces the node's connections with new ones. The old connections are closed once the
+// requests using them return. If the node can't be dialed, the old connections are kept. The pool's
+// lock must be held
+func (np *nodePool) redial(node *accessNode, now time.Time) {
+	node.redials++
+	node.unavailable = 0
+	node.redialAt = now.Add(nodeBackoff(node.redials))
+
+	conns, err := np.connect(node)
+	if err != nil {
+		return
+	}
+
+	for _, conn := range node.conns {
+		conn.retired = true
+		if conn.inFlight == 0 {
+			_ = conn.client.Close()
+		}
+	}
+	node.conns = conns
+}
+
+// release marks a request using the connection as done, and closes the connection if it was
+// replaced and this was the last request using it
+func (np *nodePool) release(conn *nodeConn) {
+	np.mu.Lock()
+	defer np.mu.Unlock()
+
+	conn.inFlight--
+	if conn.retired && conn.inFlight == 0 {
+		_ = conn.client.Close()
+	}
+}
+
+// markFailed puts the node into backoff and moves on to the next node
+func (np *nodePool) markFailed(node *accessNode, err error) {
+	np.mu.Lock()
+	defer np.mu.Unlock()
+
+	node.failures++
+	node.retryAt = time.Now().Add(nodeBackoff(node.failures))
+
+	if grpcCode(err) == codes.Unavailable {
+		node.unavailable++
+	} else {
+		node.unavailable = 0
+	}
+
+	np.current = (node.index + 1) % len(np.nodes)
+}
+
+func (np *nodePool) markHealthy(node *accessNode) {
+	np.mu.Lock()
+	defer np.mu.Unlock()
+
+	node.failures = 0
+	node.retryAt = time.Time{}
+	node.unavailable = 0
+	node.redials = 0
+	node.redialAt = time.Time{}
+}
+
+// nodeBackoff returns the backoff after the given number of consecutive failures, doubling from
+// minNodeBackoff up to maxNodeBackoff
+func nodeBackoff(failures int) time.Duration {
+	backoff := minNodeBackoff << (failures - 1)
+	if backoff > maxNodeBackoff || backoff <= 0 {
+		backoff = maxNodeBackoff
+	}
+	return backoff
+}
+
+// close closes all connections to the node, including those still in use. The pool's lock must be
+// held
+func (n *accessNode) close() error {
+	var err error
+	for _, conn := range n.conns {
+		if closeErr := conn.client.Close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+	}
+	n.conns = nil
+	return err
+}
+
+// WithConnectionPool opens size connections to each access node, and distributes requests across
+// them round-robin. This avoids the stream limits of a single connection when many requests are
+// sent concurrently, e.g. with PollConcurrency. It only applies to pollers created with
+// NewEventPollerWithConfig or NewEventPollerWithNodes, since other pollers don't manage their
+// client's connections.
+func WithConnectionPool(size int) Option {
+	return func(p *EventPoller) {
+		pool, ok := p.ownedClient.(*nodePool)
+		if !ok || size < 1 {
+			return
+		}
+
+		pool.mu.Lock()
+		defer pool.mu.Unlock()
+
+		pool.connsPerNode = size
+	}
+}
+
+// grpcCode returns the gRPC status code for an error, unwrapping it if necessary
+func grpcCode(err error) codes.Code {
+	var se interface{ GRPCStatus() *status.Status }
+	if errors.As(err, &se) {
+		return se.GRPCStatus().Code()
+	}
+	return status.Code(err)
+}
+
+// isTransientError returns true if the error is likely to succeed if retried, possibly on another
+// node
+func isTransientError(err error) bool {
+	switch grpcCode(err) {
+	case codes.Unavailable, codes.DeadlineExceeded:
+		return true
+	case codes.ResourceExhausted:
+		// responses over the max message size are too large for every node
+		return !isMessageTooLarge(err)
+	}
+	return false
+}
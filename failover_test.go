@@ -0,0 +1,172 @@
+package poller
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/onflow/flow-go-sdk/client"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestIsTransientError(t *testing.T) {
+	tests := []struct {
+		err       error
+		transient bool
+	}{
+		{status.Error(codes.Unavailable, "connection refused"), true},
+		{status.Error(codes.DeadlineExceeded, "deadline exceeded"), true},
+		{status.Error(codes.ResourceExhausted, "rate limited"), true},
+		{fmt.Errorf("error getting events: %w", status.Error(codes.Unavailable, "unavailable")), true},
+		{status.Error(codes.ResourceExhausted, "grpc: received message larger than max (5000000 vs. 4194304)"), false},
+		{status.Error(codes.InvalidArgument, "invalid height range"), false},
+		{status.Error(codes.NotFound, "block not found"), false},
+	}
+
+	for _, test := range tests {
+		if got := isTransientError(test.err); got != test.transient {
+			t.Errorf("isTransientError(%v) = %v, expected %v", test.err, got, test.transient)
+		}
+	}
+}
+
+// fakeDialer returns a dial function that connects to the fake client for each url
+func fakeDialer(clients map[string]*fakeClient) func(url string, opts ...grpc.DialOption) (nodeClient, error) {
+	return func(url string, _ ...grpc.DialOption) (nodeClient, error) {
+		c, ok := clients[url]
+		if !ok {
+			return nil, fmt.Errorf("unknown access node %s", url)
+		}
+		return c, nil
+	}
+}
+
+func TestFailoverToNextNode(t *testing.T) {
+	node1 := newFakeClient(10)
+	node1.onEvents = func(client.EventRangeQuery) error {
+		return status.Error(codes.Unavailable, "connection refused")
+	}
+
+	node2 := newFakeClient(10)
+	node2.addEvent(testEvent, 5)
+
+	pool := newNodePool([]string{"node1", "node2"}, nil)
+	pool.dial = fakeDialer(map[string]*fakeClient{"node1": node1, "node2": node2})
+
+	p := newTestPoller(pool)
+	sub := p.Subscribe([]string{testEvent}, WithBufferSize(10))
+
+	if height := poll(t, p, 0); height != 10 {
+		t.Fatalf("expected to poll up to height 10, got %d", height)
+	}
+	if got := heights(receive(sub)); !reflect.DeepEqual(got, []uint64{5}) {
+		t.Fatalf("expected events at heights [5] from the second node, got %v", got)
+	}
+	if len(node1.eventQueries()) != 1 || len(node2.eventQueries()) != 1 {
+		t.Fatalf("expected the query to be sent to each node once, got %d and %d",
+			len(node1.eventQueries()), len(node2.eventQueries()))
+	}
+
+	// the first node is backed off, and requests go to the second node
+	failed := pool.nodes[0]
+	if failed.failures != 1 || !failed.retryAt.After(time.Now()) {
+		t.Fatalf("expected the first node to be backed off, got %d failures, retry at %s", failed.failures, failed.retryAt)
+	}
+	if pool.current != 1 {
+		t.Fatalf("expected requests to go to the second node, got node %d", pool.current)
+	}
+}
+
+func TestRedialAfterPersistentUnavailable(t *testing.T) {
+	unavailable := func(client.EventRangeQuery) error {
+		return status.Error(codes.Unavailable, "connection refused")
+	}
+
+	// the first request to the first connection stays in flight until released
+	started := make(chan struct{})
+	release := make(chan struct{})
+	first := newFakeClient(10)
+	first.onEvents = func(query client.EventRangeQuery) error {
+		if query.StartHeight == 1 {
+			close(started)
+			<-release
+			return nil
+		}
+		return unavailable(query)
+	}
+
+	second := newFakeClient(10)
+	second.onEvents = unavailable
+	third := newFakeClient(10)
+
+	conns := []*fakeClient{first, second, third}
+	dials := 0
+
+	pool := newNodePool([]string{"node"}, nil)
+	pool.dial = func(string, ...grpc.DialOption) (nodeClient, error) {
+		c := conns[dials]
+		dials++
+		return c, nil
+	}
+
+	ctx := context.Background()
+	done := make(chan error, 1)
+	go func() {
+		_, err := pool.GetEventsForHeightRange(ctx, client.EventRangeQuery{Type: testEvent, StartHeight: 1, EndHeight: 1})
+		done <- err
+	}()
+	<-started
+
+	query := client.EventRangeQuery{Type: testEvent, StartHeight: 2, EndHeight: 2}
+	request := func() error {
+		_, err := pool.GetEventsForHeightRange(ctx, query)
+		return err
+	}
+
+	// the node is redialed once it keeps failing with Unavailable
+	for i := 0; i <= redialAfterFailures; i++ {
+		if err := request(); grpcCode(err) != codes.Unavailable {
+			t.Fatalf("expected an Unavailable error, got %v", err)
+		}
+	}
+	if dials != 2 {
+		t.Fatalf("expected the node to be redialed once, got %d dials", dials)
+	}
+
+	// the replaced connection is closed once the request using it returns
+	if first.isClosed() {
+		t.Fatal("expected the replaced connection to stay open while a request is using it")
+	}
+	close(release)
+	if err := <-done; err != nil {
+		t.Fatalf("unexpected error from the request in flight: %v", err)
+	}
+	if !first.isClosed() {
+		t.Fatal("expected the replaced connection to be closed once its last request returned")
+	}
+
+	// the node isn't redialed again until the backoff expires
+	for i := 1; i <= redialAfterFailures; i++ {
+		if err := request(); grpcCode(err) != codes.Unavailable {
+			t.Fatalf("expected an Unavailable error, got %v", err)
+		}
+	}
+	if dials != 2 {
+		t.Fatalf("expected no redial during the backoff, got %d dials", dials)
+	}
+
+	pool.mu.Lock()
+	pool.nodes[0].redialAt = time.Time{}
+	pool.mu.Unlock()
+
+	if err := request(); err != nil {
+		t.Fatalf("expected the request to succeed after redialing, got %v", err)
+	}
+	if dials != 3 || !second.isClosed() {
+		t.Fatalf("expected the node to be redialed and the old connection closed, got %d dials", dials)
+	}
+}
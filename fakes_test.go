@@ -0,0 +1,301 @@
+package poller
+
+import (
+	"context"
+	"encoding/binary"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/onflow/flow-go-sdk"
+	"github.com/onflow/flow-go-sdk/client"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// genesisTime is the timestamp of block 0 in the fake chain. Each block is one second after the last
+var genesisTime = time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// fakeClient is an in-memory Client serving a chain of blocks from root to latest. Blocks outside
+// that range return NotFound, like an access node that only serves blocks from its spork root
+type fakeClient struct {
+	mu     sync.Mutex
+	root   uint64
+	latest uint64
+	events map[string]map[uint64][]flow.Event
+
+	// systemEvents are the event types emitted by the system chunk, which are only returned by event
+	// queries, and not by transaction results
+	systemEvents map[string]bool
+
+	// queries records the event queries received, in order
+	queries []client.EventRangeQuery
+
+	// onEvents is called before each event query. If it returns a non-nil error, the query fails
+	// with it
+	onEvents func(query client.EventRangeQuery) error
+
+	// transform is applied to the results of each successful event query
+	transform func(query client.EventRangeQuery, blockEvents []client.BlockEvents) []client.BlockEvents
+
+	closed bool
+}
+
+var _ Client = (*fakeClient)(nil)
+
+func newFakeClient(latest uint64) *fakeClient {
+	return &fakeClient{
+		latest: latest,
+		events: make(map[string]map[uint64][]flow.Event),
+	}
+}
+
+// addEvent adds an event of the given type at height. Each event is emitted by its own transaction,
+// indexed in the order events are added to the block
+func (c *fakeClient) addEvent(eventType string, height uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	index := 0
+	for _, blocks := range c.events {
+		index += len(blocks[height])
+	}
+
+	if c.events[eventType] == nil {
+		c.events[eventType] = make(map[uint64][]flow.Event)
+	}
+	c.events[eventType][height] = append(c.events[eventType][height], flow.Event{
+		Type:             eventType,
+		TransactionID:    fakeID(height),
+		TransactionIndex: index,
+	})
+}
+
+func (c *fakeClient) setLatest(height uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.latest = height
+}
+
+// eventQueries returns the event queries received so far
+func (c *fakeClient) eventQueries() []client.EventRangeQuery {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]client.EventRangeQuery{}, c.queries...)
+}
+
+func (c *fakeClient) header(height uint64) (*flow.BlockHeader, error) {
+	if height < c.root || height > c.latest {
+		return nil, status.Errorf(codes.NotFound, "block at height %d not found", height)
+	}
+
+	return &flow.BlockHeader{
+		ID:        fakeID(height),
+		ParentID:  fakeID(height - 1),
+		Height:    height,
+		Timestamp: genesisTime.Add(time.Duration(height) * time.Second),
+	}, nil
+}
+
+func (c *fakeClient) GetLatestBlockHeader(_ context.Context, _ bool, _ ...grpc.CallOption) (*flow.BlockHeader, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.header(c.latest)
+}
+
+func (c *fakeClient) GetBlockHeaderByHeight(_ context.Context, height uint64, _ ...grpc.CallOption) (*flow.BlockHeader, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.header(height)
+}
+
+func (c *fakeClient) GetBlockHeaderByID(_ context.Context, blockID flow.Identifier, _ ...grpc.CallOption) (*flow.BlockHeader, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.header(binary.BigEndian.Uint64(blockID[24:]))
+}
+
+func (c *fakeClient) GetEventsForHeightRange(_ context.Context, query client.EventRangeQuery, _ ...grpc.CallOption) ([]client.BlockEvents, error) {
+	c.mu.Lock()
+	c.queries = append(c.queries, query)
+	onEvents := c.onEvents
+	c.mu.Unlock()
+
+	if onEvents != nil {
+		if err := onEvents(query); err != nil {
+			return nil, err
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if query.StartHeight > query.EndHeight {
+		return nil, status.Errorf(codes.InvalidArgument, "start height %d is after end height %d", query.StartHeight, query.EndHeight)
+	}
+	if query.StartHeight < c.root || query.EndHeight > c.latest {
+		return nil, status.Errorf(codes.NotFound, "heights %d-%d not found", query.StartHeight, query.EndHeight)
+	}
+
+	var blockEvents []client.BlockEvents
+	for height := query.StartHeight; ; height++ {
+		header, _ := c.header(height)
+		blockEvents = append(blockEvents, client.BlockEvents{
+			BlockID:        header.ID,
+			Height:         height,
+			BlockTimestamp: header.Timestamp,
+			Events:         append([]flow.Event{}, c.events[query.Type][height]...),
+		})
+
+		if height == query.EndHeight {
+			break
+		}
+	}
+
+	if c.transform != nil {
+		blockEvents = c.transform(query, blockEvents)
+	}
+	return blockEvents, nil
+}
+
+func (c *fakeClient) GetAccountAtLatestBlock(_ context.Context, address flow.Address, _ ...grpc.CallOption) (*flow.Account, error) {
+	return nil, status.Errorf(codes.NotFound, "account %s not found", address)
+}
+
+// GetBlockByHeight returns a block with a single collection, whose ID is the block's ID
+func (c *fakeClient) GetBlockByHeight(_ context.Context, height uint64, _ ...grpc.CallOption) (*flow.Block, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	header, err := c.header(height)
+	if err != nil {
+		return nil, err
+	}
+	return &flow.Block{
+		BlockHeader: *header,
+		BlockPayload: flow.BlockPayload{
+			CollectionGuarantees: []*flow.CollectionGuarantee{{CollectionID: header.ID}},
+		},
+	}, nil
+}
+
+// GetCollection returns a collection with a single transaction, whose ID is the block's ID
+func (c *fakeClient) GetCollection(_ context.Context, colID flow.Identifier, _ ...grpc.CallOption) (*flow.Collection, error) {
+	return &flow.Collection{TransactionIDs: []flow.Identifier{colID}}, nil
+}
+
+// GetTransactionResult returns all events in the block, except those emitted by the system chunk
+func (c *fakeClient) GetTransactionResult(_ context.Context, txID flow.Identifier, _ ...grpc.CallOption) (*flow.TransactionResult, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	height := binary.BigEndian.Uint64(txID[24:])
+
+	var events []flow.Event
+	for eventType, blocks := range c.events {
+		if !c.systemEvents[eventType] {
+			events = append(events, blocks[height]...)
+		}
+	}
+	sortEvents(events)
+
+	return &flow.TransactionResult{Status: flow.TransactionStatusSealed, Events: events}, nil
+}
+
+// Close marks the client as closed, so it can be used as a connection to an access node
+func (c *fakeClient) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closed = true
+	return nil
+}
+
+func (c *fakeClient) isClosed() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.closed
+}
+
+// fakeID returns a deterministic ID for the block at height
+func fakeID(height uint64) flow.Identifier {
+	var id flow.Identifier
+	binary.BigEndian.PutUint64(id[24:], height)
+	return id
+}
+
+// fakeClock is a Clock whose timers fire immediately. It records the requested waits
+type fakeClock struct {
+	mu    sync.Mutex
+	now   time.Time
+	waits []time.Duration
+}
+
+var _ Clock = (*fakeClock)(nil)
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.waits = append(c.waits, d)
+	c.now = c.now.Add(d)
+
+	ch := make(chan time.Time, 1)
+	ch <- c.now
+	return ch
+}
+
+func (c *fakeClock) recordedWaits() []time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]time.Duration{}, c.waits...)
+}
+
+// newTestPoller returns a poller using c, a fake clock and no logging
+func newTestPoller(c Client, opts ...Option) *EventPoller {
+	opts = append([]Option{WithClock(&fakeClock{now: genesisTime}), WithLogger(NopLogger{})}, opts...)
+	return NewEventPoller(c, time.Second, opts...)
+}
+
+// poll runs a single polling cycle after height, and returns the last processed height
+func poll(t *testing.T, p *EventPoller, after uint64) uint64 {
+	t.Helper()
+
+	ctx := context.Background()
+	header, err := p.checkSubscriptions(ctx, ctx, &flow.BlockHeader{ID: fakeID(after), Height: after})
+	if err != nil {
+		t.Fatalf("unexpected error polling after height %d: %v", after, err)
+	}
+	return header.Height
+}
+
+// receive returns the events buffered on the subscription's channel without blocking
+func receive(sub *Subscription) []*BlockEvent {
+	var events []*BlockEvent
+	for {
+		select {
+		case event, ok := <-sub.Channel:
+			if !ok {
+				return events
+			}
+			events = append(events, event)
+		default:
+			return events
+		}
+	}
+}
+
+// heights returns the block heights of events
+func heights(events []*BlockEvent) []uint64 {
+	hs := make([]uint64, 0, len(events))
+	for _, event := range events {
+		hs = append(hs, event.BlockHeight)
+	}
+	return hs
+}
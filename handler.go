@@ -0,0 +1,104 @@
+package poller
+
+import (
+	"context"
+	"sync"
+)
+
+// SubscribeHandler creates a subscription for a list of events that calls handler with each event,
+// instead of sending it to a channel. The handler is called from the polling loop, so polling waits
+// for it to return, and calls for a subscription are never concurrent. Errors returned by handler
+// are handled according to PollingErrorBehavior once the current height range is delivered: with
+// ErrorBehaviorStop, Run returns ErrAbort, otherwise the error is sent to the subscription's Errors
+// channel and polling continues. The handler may cancel its own subscription, or close the poller,
+// in which case it's not called again.
+func (p *EventPoller) SubscribeHandler(events []string, handler func(context.Context, *BlockEvent) error, opts ...SubscribeOption) *Subscription {
+	sub := newSubscription(events, opts)
+	sub.handler = handler
+
+	return p.subscribe(sub)
+}
+
+// handle calls the subscription's handler with the event, and records any error it returns. It
+// returns false if the context was cancelled. Events for closed subscriptions are skipped
+func (p *EventPoller) handle(ctx context.Context, sub *Subscription, event *BlockEvent) bool {
+	sub.handlerMu.Lock()
+	defer sub.handlerMu.Unlock()
+
+	select {
+	case <-sub.done:
+		event.ack.release()
+		return true
+	default:
+	}
+
+	err := sub.handler(ctx, event)
+	if ctx.Err() != nil {
+		event.ack.release()
+		return false
+	}
+
+	if err != nil {
+		event.ack.finish(true)
+		eventType := ""
+		if event.Event != nil {
+			eventType = event.Event.Type
+		}
+
+		p.handlerErrors.add(sub, &SubscriptionError{
+			EventType:   eventType,
+			StartHeight: event.BlockHeight,
+			EndHeight:   event.BlockHeight,
+			Terminal:    p.PollingErrorBehavior == ErrorBehaviorStop,
+			Err:         err,
+		})
+		return true
+	}
+
+	event.ack.finish(false)
+	return true
+}
+
+// checkHandlerErrors applies PollingErrorBehavior to the errors returned by handlers since the last
+// check. It returns ErrAbort if the poller should stop
+func (p *EventPoller) checkHandlerErrors() error {
+	for _, herr := range p.handlerErrors.drain() {
+		p.log.Errorf("subscription %s handler: %v", herr.sub.ID, herr.err)
+		p.stats.setError(herr.err, p.clock.Now())
+
+		if p.PollingErrorBehavior == ErrorBehaviorStop {
+			p.notifyError(p.allSubscribers(), herr.err)
+			return ErrAbort
+		}
+
+		p.notifyError([]*Subscription{herr.sub}, herr.err)
+	}
+	return nil
+}
+
+type handlerError struct {
+	sub *Subscription
+	err *SubscriptionError
+}
+
+// handlerErrors collects the errors returned by handlers while a height range is delivered
+type handlerErrors struct {
+	mu   sync.Mutex
+	errs []handlerError
+}
+
+func (h *handlerErrors) add(sub *Subscription, err *SubscriptionError) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.errs = append(h.errs, handlerError{sub: sub, err: err})
+}
+
+// drain returns the collected errors, and resets the collection
+func (h *handlerErrors) drain() []handlerError {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	errs := h.errs
+	h.errs = nil
+	return errs
+}
@@ -0,0 +1,73 @@
+package poller
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestHandlerCancelsOwnSubscription(t *testing.T) {
+	c := newFakeClient(10)
+	c.addEvent(testEvent, 2)
+	c.addEvent(testEvent, 4)
+	c.addEvent(testEvent, 6)
+
+	p := newTestPoller(c)
+
+	var handled []uint64
+	var sub *Subscription
+	sub = p.SubscribeHandler([]string{testEvent}, func(_ context.Context, event *BlockEvent) error {
+		handled = append(handled, event.BlockHeight)
+		sub.Cancel()
+		return nil
+	})
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		poll(t, p, 0)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("polling deadlocked after the handler cancelled its subscription")
+	}
+
+	if len(handled) != 1 || handled[0] != 2 {
+		t.Fatalf("expected the handler to be called once at height 2, got %v", handled)
+	}
+	if _, ok := <-sub.Errors(); ok {
+		t.Fatal("expected the subscription to be closed")
+	}
+}
+
+func TestHandlerClosesPoller(t *testing.T) {
+	c := newFakeClient(10)
+	c.addEvent(testEvent, 2)
+	c.addEvent(testEvent, 4)
+
+	p := newTestPoller(c)
+
+	calls := 0
+	p.SubscribeHandler([]string{testEvent}, func(context.Context, *BlockEvent) error {
+		calls++
+		return p.Close()
+	})
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		poll(t, p, 0)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("polling deadlocked after the handler closed the poller")
+	}
+
+	if calls != 1 {
+		t.Fatalf("expected the handler to be called once, got %d", calls)
+	}
+}
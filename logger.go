@@ -0,0 +1,35 @@
+package poller
+
+import (
+	"log"
+)
+
+// Logger is used by the poller to log messages
+type Logger interface {
+	Debugf(format string, args ...interface{})
+	Infof(format string, args ...interface{})
+	Errorf(format string, args ...interface{})
+}
+
+// StdLogger is a Logger that writes to the standard library's log package. Debug messages are
+// discarded
+type StdLogger struct{}
+
+func (StdLogger) Debugf(string, ...interface{}) {}
+
+func (StdLogger) Infof(format string, args ...interface{}) {
+	log.Printf(format, args...)
+}
+
+func (StdLogger) Errorf(format string, args ...interface{}) {
+	log.Printf(format, args...)
+}
+
+// NopLogger is a Logger that discards all messages
+type NopLogger struct{}
+
+func (NopLogger) Debugf(string, ...interface{}) {}
+
+func (NopLogger) Infof(string, ...interface{}) {}
+
+func (NopLogger) Errorf(string, ...interface{}) {}
@@ -0,0 +1,112 @@
+package poller
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/onflow/flow-go-sdk"
+)
+
+// patternRegex matches event type patterns of the form A.<address>.* and A.<address>.<Contract>.*
+var patternRegex = regexp.MustCompile(`^A\.([0-9a-fA-F]{16})\.(?:([A-Za-z_][A-Za-z0-9_]*)\.)?\*$`)
+
+// eventTypeRegex matches contract event types of the form A.<address>.<Contract>.<Event>, and core
+// protocol events of the form flow.<Event>
+var eventTypeRegex = regexp.MustCompile(`^(?:A\.[0-9a-fA-F]{16}\.[A-Za-z_][A-Za-z0-9_]*|flow)\.[A-Za-z_][A-Za-z0-9_]*$`)
+
+// eventDeclRegex matches event declarations in a contract's source code
+var eventDeclRegex = regexp.MustCompile(`\bevent\s+([A-Za-z_][A-Za-z0-9_]*)\s*\(`)
+
+// SubscribeMatching creates a subscription for all events matching a pattern. Patterns can match
+// all events of a contract (e.g. A.1654653399040a61.FlowToken.*) or of all contracts deployed to
+// an account (e.g. A.1654653399040a61.*).
+//
+// The access API can only query events by their exact type, so the poller expands patterns into
+// event types by fetching the matching contracts from the account at the start of each polling
+// cycle, and finding their event declarations. This adds a request per account each cycle, and
+// events are only delivered for contracts that are currently deployed, so events from removed
+// contracts are missed.
+func (p *EventPoller) SubscribeMatching(pattern string, opts ...SubscribeOption) (*Subscription, error) {
+	if !isPattern(pattern) {
+		return nil, fmt.Errorf("invalid event pattern: %s", pattern)
+	}
+
+	sub := newSubscription([]string{pattern}, opts)
+	sub.Channel = make(chan *BlockEvent, sub.bufferSize)
+
+	return p.subscribe(sub), nil
+}
+
+// ValidateEventType returns an error if event is not a valid contract event type
+// (A.<address>.<Contract>.<Event>), core protocol event type (flow.<Event>), or event pattern
+func ValidateEventType(event string) error {
+	if eventTypeRegex.MatchString(event) || isPattern(event) {
+		return nil
+	}
+	return fmt.Errorf("invalid event type: %s", event)
+}
+
+func isPattern(event string) bool {
+	return patternRegex.MatchString(event)
+}
+
+func matchesPattern(pattern, eventType string) bool {
+	return strings.HasPrefix(eventType, strings.TrimSuffix(pattern, "*"))
+}
+
+// expandPatterns resolves all subscribed patterns into the event types they currently match. If an
+// account can't be fetched, the types from the previous expansion are kept.
+func (p *EventPoller) expandPatterns(ctx context.Context) {
+	p.mu.RLock()
+	var patterns []string
+	for event := range p.subscriptions {
+		if isPattern(event) {
+			patterns = append(patterns, event)
+		}
+	}
+	p.mu.RUnlock()
+
+	if len(patterns) == 0 {
+		return
+	}
+
+	accounts := make(map[flow.Address]*flow.Account)
+	expanded := make(map[string][]string)
+	for _, pattern := range patterns {
+		matches := patternRegex.FindStringSubmatch(pattern)
+		address := flow.HexToAddress(matches[1])
+
+		account, ok := accounts[address]
+		if !ok {
+			var err error
+			account, err = p.client.GetAccountAtLatestBlock(ctx, address)
+			if err != nil {
+				p.metrics.ObservePollError("")
+				p.log.Errorf("error getting account %s to expand event pattern: %v", address, err)
+				continue
+			}
+			accounts[address] = account
+		}
+
+		var types []string
+		for name, code := range account.Contracts {
+			if matches[2] != "" && matches[2] != name {
+				continue
+			}
+
+			for _, decl := range eventDeclRegex.FindAllStringSubmatch(string(code), -1) {
+				types = append(types, fmt.Sprintf("A.%s.%s.%s", address.Hex(), name, decl[1]))
+			}
+		}
+		expanded[pattern] = types
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for pattern, types := range expanded {
+		p.patternTypes[pattern] = types
+	}
+}
@@ -0,0 +1,33 @@
+package poller
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCoreProtocolEvents(t *testing.T) {
+	const accountCreated = "flow.AccountCreated"
+
+	if err := ValidateEventType(accountCreated); err != nil {
+		t.Fatalf("expected %s to be a valid event type: %v", accountCreated, err)
+	}
+
+	c := newFakeClient(10)
+	c.addEvent(accountCreated, 4)
+	c.addEvent(accountCreated, 9)
+
+	p := newTestPoller(c)
+	p.StrictEventTypes = true
+	sub := p.Subscribe([]string{accountCreated}, WithBufferSize(10))
+	poll(t, p, 0)
+
+	events := receive(sub)
+	if got := heights(events); !reflect.DeepEqual(got, []uint64{4, 9}) {
+		t.Fatalf("expected events at heights [4 9], got %v", got)
+	}
+	for _, event := range events {
+		if event.Event.Type != accountCreated {
+			t.Fatalf("expected a %s event, got %s", accountCreated, event.Event.Type)
+		}
+	}
+}
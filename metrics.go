@@ -0,0 +1,32 @@
+package poller
+
+// Metrics receives observations about the poller's progress, and can be used to export metrics to
+// a monitoring system such as Prometheus. Implementations must be safe for concurrent use
+type Metrics interface {
+	// ObserveBlocksPolled is called after a height range is processed with the number of blocks
+	// in the range
+	ObserveBlocksPolled(count uint64)
+
+	// ObserveEventsDelivered is called after polling an event type with the number of events
+	// delivered to subscribers
+	ObserveEventsDelivered(eventType string, count int)
+
+	// ObservePollError is called when a request to the access node fails. eventType is empty if the
+	// error was not specific to an event type
+	ObservePollError(eventType string)
+
+	// ObserveLagHeight is called each polling cycle with the number of blocks between the latest
+	// sealed block and the last processed block
+	ObserveLagHeight(lag uint64)
+}
+
+// NopMetrics is a Metrics implementation that discards all observations
+type NopMetrics struct{}
+
+func (NopMetrics) ObserveBlocksPolled(uint64) {}
+
+func (NopMetrics) ObserveEventsDelivered(string, int) {}
+
+func (NopMetrics) ObservePollError(string) {}
+
+func (NopMetrics) ObserveLagHeight(uint64) {}
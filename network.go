@@ -0,0 +1,83 @@
+package poller
+
+import (
+	"fmt"
+)
+
+// Network identifies a Flow network, and is used to look up the addresses of its core contracts
+type Network int
+
+const (
+	Mainnet Network = iota
+	Testnet
+	Emulator
+)
+
+func (n Network) String() string {
+	switch n {
+	case Mainnet:
+		return "mainnet"
+	case Testnet:
+		return "testnet"
+	case Emulator:
+		return "emulator"
+	}
+	return fmt.Sprintf("Network(%d)", int(n))
+}
+
+// contractAddresses are the addresses of the core contracts on each network, without the 0x prefix
+var contractAddresses = map[string]map[Network]string{
+	"FungibleToken": {
+		Mainnet:  "f233dcee88fe0abe",
+		Testnet:  "9a0766d93b6608b7",
+		Emulator: "ee82856bf20e2aa6",
+	},
+	"FlowToken": {
+		Mainnet:  "1654653399040a61",
+		Testnet:  "7e60df042a9c0868",
+		Emulator: "0ae53cb6e3f42a79",
+	},
+	"NonFungibleToken": {
+		Mainnet:  "1d7e57aa55817448",
+		Testnet:  "631e88ae7f1d7c20",
+		Emulator: "f8d6e0586b0a20c7",
+	},
+	"FlowFees": {
+		Mainnet:  "f919ee77447b7497",
+		Testnet:  "912d5440f7e3769e",
+		Emulator: "e5a8b7f23e8b548f",
+	},
+}
+
+// ContractEvent returns the event type for an event of a core contract on the network, e.g.
+// ContractEvent(Mainnet, "FlowToken", "TokensDeposited") returns
+// "A.1654653399040a61.FlowToken.TokensDeposited". It panics if the contract's address on the network
+// is not known.
+func ContractEvent(net Network, contract, event string) string {
+	address, ok := contractAddresses[contract][net]
+	if !ok {
+		panic(fmt.Sprintf("unknown address for contract %s on %s", contract, net))
+	}
+	return fmt.Sprintf("A.%s.%s.%s", address, contract, event)
+}
+
+// FungibleTokenEvent returns the event type for an event of the FungibleToken contract on the network
+func FungibleTokenEvent(net Network, event string) string {
+	return ContractEvent(net, "FungibleToken", event)
+}
+
+// FlowTokenEvent returns the event type for an event of the FlowToken contract on the network
+func FlowTokenEvent(net Network, event string) string {
+	return ContractEvent(net, "FlowToken", event)
+}
+
+// NonFungibleTokenEvent returns the event type for an event of the NonFungibleToken contract on the
+// network
+func NonFungibleTokenEvent(net Network, event string) string {
+	return ContractEvent(net, "NonFungibleToken", event)
+}
+
+// FlowFeesEvent returns the event type for an event of the FlowFees contract on the network
+func FlowFeesEvent(net Network, event string) string {
+	return ContractEvent(net, "FlowFees", event)
+}
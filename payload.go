@@ -0,0 +1,63 @@
+package poller
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/onflow/cadence"
+	jsoncdc "github.com/onflow/cadence/encoding/json"
+	"github.com/onflow/flow-go-sdk"
+)
+
+// DecodePayload returns the event's payload decoded as a cadence.Event. The payload is decoded at
+// most once, and the result is shared by all subscriptions that receive the event.
+//
+// Payloads are expected to be JSON-CDC encoded. CCF encoded payloads are not supported, since the
+// pinned cadence version doesn't include a CCF decoder, and the access API version used by the
+// pinned flow-go-sdk doesn't allow requesting a different encoding
+func (e *BlockEvent) DecodePayload() (cadence.Event, error) {
+	if e.payload == nil {
+		return decodeEvent(e.Event)
+	}
+	return e.payload.decode()
+}
+
+// eventPayload caches the decoded payload of an event
+type eventPayload struct {
+	once  sync.Once
+	event *flow.Event
+	value cadence.Event
+	err   error
+}
+
+func newEventPayload(event *flow.Event) *eventPayload {
+	return &eventPayload{
+		event: event,
+	}
+}
+
+func (p *eventPayload) decode() (cadence.Event, error) {
+	p.once.Do(func() {
+		p.value, p.err = decodeEvent(p.event)
+	})
+	return p.value, p.err
+}
+
+func decodeEvent(event *flow.Event) (cadence.Event, error) {
+	// the sdk decodes the payload when converting the response, so use it if it's available
+	if event.Value.EventType != nil {
+		return event.Value, nil
+	}
+
+	value, err := jsoncdc.Decode(event.Payload)
+	if err != nil {
+		return cadence.Event{}, fmt.Errorf("error decoding event payload: %w", err)
+	}
+
+	eventValue, ok := value.(cadence.Event)
+	if !ok {
+		return cadence.Event{}, fmt.Errorf("expected event payload, got %T", value)
+	}
+
+	return eventValue, nil
+}
@@ -4,12 +4,19 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"log"
+	"io"
+	"math"
 	"math/rand"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/onflow/flow-go-sdk"
 	"github.com/onflow/flow-go-sdk/client"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 )
 
 const DefaultMaxHeightRange = 250
@@ -17,7 +24,8 @@ const DefaultMaxHeightRange = 250
 type ErrorBehavior int
 
 const (
-	// ErrorBehaviorContinue will log the error, but continue processing events
+	// ErrorBehaviorContinue will log the error, but continue processing events. Event types that
+	// failed to poll are queried again from the failed height with the next range
 	ErrorBehaviorContinue ErrorBehavior = iota
 
 	// ErrorBehaviorStop will log the error, and stop processing events
@@ -26,101 +34,577 @@ const (
 
 var ErrAbort = fmt.Errorf("polling aborted due to an error")
 
+// ErrStartHeightInFuture is returned by Run when StartHeight is after the latest block, unless
+// WaitForStartHeight is set
+var ErrStartHeightInFuture = fmt.Errorf("start height is after the latest block")
+
+// Client is the subset of the access API used by the poller. It's implemented by the flow-go-sdk's
+// *client.Client, and can be replaced with a mock for testing
+type Client interface {
+	GetLatestBlockHeader(ctx context.Context, isSealed bool, opts ...grpc.CallOption) (*flow.BlockHeader, error)
+	GetBlockHeaderByHeight(ctx context.Context, height uint64, opts ...grpc.CallOption) (*flow.BlockHeader, error)
+	GetBlockHeaderByID(ctx context.Context, blockID flow.Identifier, opts ...grpc.CallOption) (*flow.BlockHeader, error)
+	GetEventsForHeightRange(ctx context.Context, query client.EventRangeQuery, opts ...grpc.CallOption) ([]client.BlockEvents, error)
+	GetAccountAtLatestBlock(ctx context.Context, address flow.Address, opts ...grpc.CallOption) (*flow.Account, error)
+	GetBlockByHeight(ctx context.Context, height uint64, opts ...grpc.CallOption) (*flow.Block, error)
+	GetCollection(ctx context.Context, colID flow.Identifier, opts ...grpc.CallOption) (*flow.Collection, error)
+	GetTransactionResult(ctx context.Context, txID flow.Identifier, opts ...grpc.CallOption) (*flow.TransactionResult, error)
+}
+
+var _ Client = (*client.Client)(nil)
+
 type EventPoller struct {
-	// StartHeight sets the starting height for the event poller. If not set, the latest sealed
-	// block height is used
+	// StartHeight sets the first height processed by the event poller. Events at StartHeight are
+	// included. If not set, polling starts after the latest sealed block
 	StartHeight uint64
 
+	// WaitForStartHeight configures the poller to wait for the chain to reach StartHeight when it's
+	// after the latest block, instead of returning ErrStartHeightInFuture
+	WaitForStartHeight bool
+
+	// StartBlockID sets the first block processed by the event poller by its ID. Events in the
+	// block are included, unless it's the first block of the chain at height 0, in which case
+	// polling starts after it. StartHeight takes precedence if both are set
+	StartBlockID flow.Identifier
+
+	// StartTime sets the starting point for the event poller as a block timestamp. Polling starts
+	// with the first block at or after StartTime. StartHeight and StartBlockID take precedence if
+	// set
+	StartTime time.Time
+
+	// EndHeight sets the last height to process. When set, Run returns once all events up to and
+	// including EndHeight have been delivered. If not set, the poller runs until the context is
+	// cancelled
+	EndHeight uint64
+
+	// FollowFinalized configures the poller to follow the latest finalized block instead of the
+	// latest sealed block. This reduces latency. Finalized blocks are never orphaned, but they may
+	// not have execution results yet, which are only added once the block is sealed, so requests
+	// for recently finalized blocks may fail or return no events until the block is executed. If an
+	// access node reports a different block at a height that was already processed, subscribers
+	// receive a Reorg notification.
+	FollowFinalized bool
+
+	// ConfirmationDepth sets the number of blocks behind the latest sealed (or finalized) block to
+	// stay, so only blocks with at least ConfirmationDepth blocks after them are processed. Defaults
+	// to 0
+	ConfirmationDepth uint64
+
 	// PollingErrorBehavior sets the behavior when errors are encountered while polling for events.
 	PollingErrorBehavior ErrorBehavior
 
-	client        *client.Client
-	interval      time.Duration
+	// MaxLagBlocks sets the max number of blocks the poller may fall behind the chain's tip. When
+	// the lag is larger at the start of a polling cycle, OnMaxLagExceeded is called, and with
+	// MaxLagBehavior set to ErrorBehaviorStop, Run returns ErrAbort. Disabled when 0
+	MaxLagBlocks uint64
+
+	// MaxLagBehavior sets the behavior when the lag exceeds MaxLagBlocks. Defaults to
+	// ErrorBehaviorContinue, which keeps polling
+	MaxLagBehavior ErrorBehavior
+
+	// OnMaxLagExceeded is called with the current lag in blocks each polling cycle the lag exceeds
+	// MaxLagBlocks. It's called from the polling loop, so it should not block
+	OnMaxLagExceeded func(lag uint64)
+
+	// DeliveryPolicy sets the behavior when a subscriber is not ready to receive an event. Defaults
+	// to DeliverBlock
+	DeliveryPolicy DeliveryPolicy
+
+	// AckMode requires events to be acked with BlockEvent.Ack before the checkpoint advances past
+	// their height, giving at-least-once delivery across restarts. Nacked events are delivered again
+	// on the next cycle. Events must be acked or nacked even if they're read after unsubscribing,
+	// otherwise the checkpoint stops advancing. Handler subscriptions ack each event when the handler
+	// returns nil, and nack it when it returns an error. Only subscriptions that receive BlockEvents
+	// are tracked
+	AckMode bool
+
+	// OnProgress is called after each successful polling cycle with the last processed height, even
+	// if no events matched. This is useful as a liveness signal
+	OnProgress func(height uint64)
+
+	// OnCaughtUp is called when the poller has processed all blocks up to the chain's tip. It's
+	// called once on startup, then again each time the poller falls more than MaxHeightRange blocks
+	// behind and catches up. It's called from the polling loop, so it should not block
+	OnCaughtUp func()
+
+	// OnRangeProcessed is called after each height range is processed with its first and last
+	// heights, inclusive, and the number of events polled by type, even if no events matched. This
+	// is useful as an audit trail of the poller's progress. It's called from the polling loop, so
+	// it should not block
+	OnRangeProcessed func(start, end uint64, eventCounts map[string]uint64)
+
+	// OnBufferHighWater is called when a subscription's buffer is at least 80% full before an event
+	// is sent to it, which is an early warning that the subscriber is not keeping up. It's called
+	// again only after the buffer drains below 80%. Unbuffered subscriptions are ignored
+	OnBufferHighWater func(subID string, len, cap int)
+
+	// PollOnStart configures the poller to poll immediately when Run is called, instead of waiting
+	// for the first interval
+	PollOnStart bool
+
+	// MaxHeightRange sets the maximum number of blocks queried in a single request. Larger ranges
+	// are split into multiple requests. Defaults to DefaultMaxHeightRange
+	MaxHeightRange uint64
+
+	// PollConcurrency sets the maximum number of event types queried concurrently for each height
+	// range. Events of different types may be delivered out of order when greater than 1. Defaults
+	// to 1
+	PollConcurrency int
+
+	// OrderedDelivery configures the poller to buffer all events for each height range, and deliver
+	// them sorted by block height, transaction index and event index, regardless of event type. By
+	// default, events are delivered per event type as each type is queried. All events within a
+	// range are held in memory, so use MaxHeightRange to limit memory usage.
+	OrderedDelivery bool
+
+	// MaxBufferedEvents sets the maximum number of events fetched but not yet delivered to
+	// subscribers. With OrderedDelivery or MaxEventsPerCycle, where events are held until the range
+	// is polled, the range is shrunk to end at the last block that fits once it's exceeded, and the
+	// rest of the blocks are only queried with the next range. Otherwise, no new event types are
+	// queried until slow subscribers catch up when PollConcurrency is greater than 1. With the
+	// default PollConcurrency, each response is delivered before the next request. A single block or
+	// response may still exceed it. Defaults to 0, which disables the limit
+	MaxBufferedEvents int
+
+	// MaxEventsPerCycle sets the maximum number of events delivered to subscriptions in each polling
+	// cycle, to smooth the load on subscribers when catching up. Once reached, the rest of the
+	// blocks are left for the next cycle, and queried again then. Blocks are delivered whole, so a
+	// cycle may exceed it by the events of one block. When set, events for each height range are
+	// held until the range is polled, and delivered in block order as with OrderedDelivery.
+	// Defaults to 0, which disables the limit
+	MaxEventsPerCycle int
+
+	// DeliverEmptyRanges configures the poller to send a marker BlockEvent with Complete set to
+	// each subscription's Channel after each height range is processed, even if the range had no
+	// matching events. Consumers can use markers to track their progress
+	DeliverEmptyRanges bool
+
+	// SkipHeaderLookups configures the poller to split large height ranges by height alone, instead
+	// of fetching the header of the last block in each range. This saves a request per range while
+	// catching up. Only the height is known for these blocks, so it's ignored with FollowFinalized,
+	// which needs block IDs to detect reorgs
+	SkipHeaderLookups bool
+
+	// MaxConcurrentRanges sets the maximum number of event requests in flight at once, across all
+	// event types and height ranges. This limits the load on the access node independently of
+	// PollConcurrency. Defaults to 0, which disables the limit
+	MaxConcurrentRanges int
+
+	// FanOutConcurrency sets the maximum number of subscriptions events are delivered to
+	// concurrently. When greater than 1, the events from each response are grouped by subscription
+	// and delivered to each subscription separately, so a slow subscription doesn't delay the
+	// others. Events are still delivered in order to each subscription. Defaults to 1
+	FanOutConcurrency int
+
+	// StrictEventTypes configures Subscribe to panic when an event type is not valid (see
+	// ValidateEventType). By default, invalid event types are logged and queried anyway
+	StrictEventTypes bool
+
+	// MaxRetries sets the number of times a failed event query is retried before the error is
+	// handled according to PollingErrorBehavior. Only transient errors such as Unavailable and
+	// DeadlineExceeded are retried
+	MaxRetries int
+
+	// RetryBackoff sets the wait before the first retry. It doubles after each attempt. Defaults to
+	// DefaultRetryBackoff
+	RetryBackoff time.Duration
+
+	// BackoffBase sets the wait before retrying after a polling error. The wait is multiplied by
+	// BackoffFactor for each consecutive error, up to BackoffMax, and reset after a successful poll.
+	// If not set, the poller retries on the regular interval
+	BackoffBase time.Duration
+
+	// BackoffMax sets the maximum wait between retries. If not set, the wait is not capped
+	BackoffMax time.Duration
+
+	// BackoffFactor sets the multiplier applied to the wait after each consecutive error. Defaults
+	// to 2
+	BackoffFactor float64
+
+	client       Client
+	checkpointer Checkpointer
+	log          Logger
+	clock        Clock
+	metrics      Metrics
+	dedup        *dedupCache
+	recent       *recentBuffer
+	stats        pollerStats
+	rangeLimits  rangeLimits
+	aliases      map[string]string
+	buffered     *eventBuffer
+	schedule     *pollSchedule
+
+	// handlerErrors collects errors returned by handler subscriptions, which are checked after each
+	// height range is delivered
+	handlerErrors handlerErrors
+
+	// acks tracks the events waiting for an ack in AckMode
+	acks ackTracker
+
+	// rangeSem limits the number of event requests in flight. It's created on first use from
+	// MaxConcurrentRanges
+	rangeSem     chan struct{}
+	rangeSemOnce sync.Once
+
+	// shutdownTimeout is the maximum time to spend delivering already fetched events after the
+	// context is cancelled
+	shutdownTimeout time.Duration
+
+	// startupJitter is the maximum random delay added before the first poll
+	startupJitter time.Duration
+
+	// defaultStartHeight is the first height delivered to subscriptions without their own start
+	// height. It's only set when polling starts earlier for a subscription's start height
+	defaultStartHeight uint64
+
+	caughtUp     chan struct{}
+	caughtUpOnce sync.Once
+
+	// behind is set when the poller is not caught up to the chain's tip, so OnCaughtUp is called
+	// once it catches up. It's only accessed by Run
+	behind bool
+
+	// done is closed once Run returns
+	done     chan struct{}
+	doneOnce sync.Once
+
+	// blockIDs tracks the IDs of recently processed blocks to detect reorgs
+	blockIDsMu sync.Mutex
+	blockIDs   map[uint64]flow.Identifier
+
+	// interval is the polling interval as a time.Duration. It's accessed atomically so it can be
+	// changed with SetInterval while Run is active. intervalChanged wakes Run to adopt the new value
+	interval        int64
+	intervalChanged chan struct{}
+
+	// flush wakes Run to poll immediately. See Flush
+	flush chan struct{}
+
+	// headerMu guards lastHeader. It's only written by Run, so Run can read it without the lock
+	headerMu   sync.RWMutex
+	lastHeader *flow.BlockHeader
+
+	// ownedClient is closed when the poller is closed. It's only set when the poller created the
+	// client
+	ownedClient io.Closer
+
+	// mu guards subscriptions, patternTypes, closed, paused and processingHeight
+	mu            sync.RWMutex
 	subscriptions map[string][]*Subscription
-	lastHeader    *flow.BlockHeader
+	patternTypes  map[string][]string
+	closed        bool
+	paused        bool
+
+	// processingHeight is the last height of the range being processed, or the last processed
+	// height between ranges. Subscriptions created while Run is active start after it
+	processingHeight uint64
 }
 
 type BlockEvent struct {
 	Event *flow.Event
+
+	// BlockHeight is the height of the block the event was emitted in
+	BlockHeight uint64
+
+	// BlockTimestamp is the timestamp of the block the event was emitted in
+	BlockTimestamp time.Time
+
+	// TransactionIndex is the index of the transaction that emitted the event within the block
+	TransactionIndex int
+
+	// EventIndex is the index of the event within the transaction
+	EventIndex int
+
+	// Reorg is set when the BlockEvent is a reorg notification instead of an event. Event is nil
+	// for reorg notifications. They are only sent when FollowFinalized is enabled
+	Reorg *Reorg
+
+	// Complete is set when the BlockEvent is a marker that all events up to and including
+	// BlockHeight have been delivered. Event is nil for markers. They are only sent when
+	// DeliverEmptyRanges is enabled
+	Complete bool
+
+	payload *eventPayload
+	ack     *eventAck
 }
 
-type Subscription struct {
-	ID      string
-	Channel chan *BlockEvent
-	Events  []string
+// String returns a summary of the event for logging, including its type, transaction ID, block
+// height and event index
+func (e *BlockEvent) String() string {
+	switch {
+	case e.Reorg != nil:
+		return fmt.Sprintf("reorg heights=%d-%d", e.Reorg.StartHeight, e.Reorg.EndHeight)
+	case e.Complete:
+		return fmt.Sprintf("complete height=%d", e.BlockHeight)
+	case e.Event == nil:
+		return fmt.Sprintf("height=%d", e.BlockHeight)
+	}
+
+	return fmt.Sprintf("%s tx=%s height=%d index=%d", e.Event.Type, e.Event.TransactionID, e.BlockHeight, e.EventIndex)
 }
 
-func NewEventPoller(client *client.Client, interval time.Duration) *EventPoller {
-	return &EventPoller{
-		client:        client,
-		interval:      interval,
-		subscriptions: make(map[string][]*Subscription),
+// Option configures optional behavior of the EventPoller
+type Option func(*EventPoller)
+
+// WithCheckpointer configures a Checkpointer used to persist the last processed height. When set,
+// the saved height takes precedence over StartHeight on startup. The checkpoint only advances past
+// a range once it's polled for every event type, so a range that failed to poll is queried again
+// after a restart
+func WithCheckpointer(checkpointer Checkpointer) Option {
+	return func(p *EventPoller) {
+		p.checkpointer = checkpointer
 	}
 }
 
-// Subscribe creates a subscription for a list of events, and returns a Subscription struct, which
-// contains a channel to receive events
-func (p *EventPoller) Subscribe(events []string) *Subscription {
-	sub := &Subscription{
-		ID:      randomString(16),
-		Channel: make(chan *BlockEvent),
-		Events:  events,
+// WithLogger configures the Logger used by the poller. Defaults to StdLogger
+func WithLogger(logger Logger) Option {
+	return func(p *EventPoller) {
+		p.log = logger
 	}
+}
 
-	for _, event := range events {
-		p.subscriptions[event] = append(p.subscriptions[event], sub)
+// WithMetrics configures a Metrics implementation to receive observations about polling progress
+func WithMetrics(metrics Metrics) Option {
+	return func(p *EventPoller) {
+		p.metrics = metrics
 	}
+}
 
-	return sub
+// WithGracefulShutdown configures the poller to finish delivering events it has already fetched
+// when the context is cancelled, waiting up to timeout for subscribers to receive them before
+// returning. Events for ranges that have not been fetched yet are not delivered. When all events
+// of a range are delivered, the checkpoint and watermarks are updated before returning.
+func WithGracefulShutdown(timeout time.Duration) Option {
+	return func(p *EventPoller) {
+		p.shutdownTimeout = timeout
+	}
 }
 
-// Unsubscribe removes subscription for all provided events
-func (p *EventPoller) Unsubscribe(id string, events []string) {
-	for _, event := range events {
-		if _, ok := p.subscriptions[event]; !ok {
-			continue
-		}
+// WithStartupJitter configures the poller to wait a random duration of up to max before the first
+// poll, so multiple pollers started at the same time don't query the access node in sync. Zero
+// disables the jitter.
+func WithStartupJitter(max time.Duration) Option {
+	return func(p *EventPoller) {
+		p.startupJitter = max
+	}
+}
 
-		for i, sub := range p.subscriptions[event] {
-			if sub.ID == id {
-				p.subscriptions[event] = append(p.subscriptions[event][:i], p.subscriptions[event][i+1:]...)
-				break
-			}
-		}
+func NewEventPoller(client Client, interval time.Duration, opts ...Option) *EventPoller {
+	p := newEventPoller(client, interval)
+	for _, opt := range opts {
+		opt(p)
+	}
 
-		if len(p.subscriptions[event]) == 0 {
-			delete(p.subscriptions, event)
-		}
+	return p
+}
+
+func newEventPoller(client Client, interval time.Duration) *EventPoller {
+	return &EventPoller{
+		client:          client,
+		interval:        int64(interval),
+		intervalChanged: make(chan struct{}, 1),
+		flush:           make(chan struct{}, 1),
+		buffered:        newEventBuffer(),
+		schedule:        newPollSchedule(),
+		log:             StdLogger{},
+		clock:           RealClock{},
+		metrics:         NopMetrics{},
+		caughtUp:        make(chan struct{}),
+		behind:          true,
+		done:            make(chan struct{}),
+		subscriptions:   make(map[string][]*Subscription),
+		patternTypes:    make(map[string][]string),
 	}
 }
 
+// SetInterval changes the polling interval. It's safe to call while Run is active, in which case
+// the next poll is rescheduled using the new interval
+func (p *EventPoller) SetInterval(interval time.Duration) {
+	atomic.StoreInt64(&p.interval, int64(interval))
+
+	select {
+	case p.intervalChanged <- struct{}{}:
+	default:
+	}
+}
+
+// Flush triggers a poll immediately instead of waiting for the interval, e.g. when notified of new
+// blocks by another source. The interval restarts from the triggered poll. Calls while a flush is
+// already pending are ignored, and a flush requested before Run starts triggers the first poll
+func (p *EventPoller) Flush() {
+	select {
+	case p.flush <- struct{}{}:
+	default:
+	}
+}
+
+func (p *EventPoller) pollInterval() time.Duration {
+	return time.Duration(atomic.LoadInt64(&p.interval))
+}
+
+// LastProcessedHeight returns the height of the last block whose events have been processed. It's
+// updated after each height range, and is safe to call while Run is active or after it returns,
+// e.g. to save progress on shutdown and resume later with StartHeight set to the returned height
+// plus one. It returns 0 before Run is called
 func (p *EventPoller) LastProcessedHeight() uint64 {
+	p.headerMu.RLock()
+	defer p.headerMu.RUnlock()
+
 	if p.lastHeader == nil {
 		return 0
 	}
 	return p.lastHeader.Height
 }
 
-// Run runs the event poller
+// Close removes all subscriptions and closes their channels, so consumers ranging over them exit.
+// Subscriptions created after Close are closed immediately.
+func (p *EventPoller) Close() error {
+	p.closeSubscriptions()
+
+	if p.ownedClient != nil {
+		return p.ownedClient.Close()
+	}
+	return nil
+}
+
+func (p *EventPoller) closeSubscriptions() {
+	p.mu.Lock()
+	subs := p.subscriptions
+	p.closed = true
+	p.subscriptions = make(map[string][]*Subscription)
+	p.patternTypes = make(map[string][]string)
+	p.mu.Unlock()
+
+	for _, eventSubs := range subs {
+		for _, sub := range eventSubs {
+			sub.close()
+		}
+	}
+}
+
+// Done returns a channel that is closed once Run has returned and all subscription channels have
+// been closed. This is useful to wait for the poller to shut down before tearing down consumers.
+func (p *EventPoller) Done() <-chan struct{} {
+	return p.done
+}
+
+// CaughtUp returns a channel that is closed once the poller has processed all blocks up to the
+// chain's tip for the first time. This is useful to detect when a backfill from StartHeight is
+// complete.
+func (p *EventPoller) CaughtUp() <-chan struct{} {
+	return p.caughtUp
+}
+
+func (p *EventPoller) markCaughtUp() {
+	p.caughtUpOnce.Do(func() {
+		p.log.Infof("caught up to the latest block")
+		close(p.caughtUp)
+	})
+
+	if p.behind {
+		p.behind = false
+		if p.OnCaughtUp != nil {
+			p.OnCaughtUp()
+		}
+	}
+}
+
+// Pause stops polling until Resume is called. Subscriptions are kept, and the last processed height
+// is retained so polling resumes where it left off. A polling cycle that's in progress is completed
+func (p *EventPoller) Pause() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.paused = true
+}
+
+// Resume resumes polling after Pause. Blocks produced while paused are processed on the next cycle
+func (p *EventPoller) Resume() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.paused = false
+}
+
+// Paused returns true if polling is paused
+func (p *EventPoller) Paused() bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	return p.paused
+}
+
+// Lag returns the number of blocks between the latest sealed (or finalized, see FollowFinalized)
+// block less ConfirmationDepth and the last processed block, so it's 0 once the poller is caught up
+func (p *EventPoller) Lag(ctx context.Context) (uint64, error) {
+	latest, err := p.client.GetLatestBlockHeader(ctx, !p.FollowFinalized)
+	if err != nil {
+		return 0, fmt.Errorf("error getting latest header: %w", err)
+	}
+
+	confirmed := p.confirmedHeight(latest.Height)
+	processed := p.LastProcessedHeight()
+	if processed >= confirmed {
+		return 0, nil
+	}
+	return confirmed - processed, nil
+}
+
+// Run runs the event poller. When it returns, all subscriptions are closed
 func (p *EventPoller) Run(ctx context.Context) error {
-	var err error
-	p.lastHeader, err = p.startHeader(ctx)
+	defer func() {
+		p.closeSubscriptions()
+		p.doneOnce.Do(func() { close(p.done) })
+	}()
+
+	startHeader, err := p.startHeader(ctx)
 	if err != nil {
+		// module is shutting down while waiting for the start height
+		if ctx.Err() != nil {
+			return nil
+		}
 		return fmt.Errorf("error getting start header: %w", err)
 	}
+	p.setLastHeader(startHeader)
+
+	deliverCtx, cancelDeliver := p.deliveryContext(ctx)
+	defer cancelDeliver()
+
+	if p.reachedEndHeight() {
+		return nil
+	}
+
+	consecutiveErrors := 0
+	first := p.pollInterval()
+	if p.PollOnStart {
+		first = 0
+	}
+	if p.startupJitter > 0 {
+		first += time.Duration(rand.Int63n(int64(p.startupJitter) + 1))
+	}
 
-	next := time.After(p.interval)
+	next := p.clock.After(first)
 	for {
 		select {
 		case <-ctx.Done():
 			return nil
 
+		case <-p.intervalChanged:
+			next = p.clock.After(p.pollInterval())
+
+		case <-p.flush:
+			next = p.clock.After(0)
+
 		case <-next:
 			// restart timer immediately so the poller runs approximately every interval instead of
 			// every interval plus processing time
-			next = time.After(p.interval)
+			next = p.clock.After(p.pollInterval())
+
+			if p.Paused() {
+				continue
+			}
 
-			newLatest, err := p.checkSubscriptions(ctx, p.lastHeader)
+			newLatest, err := p.checkSubscriptions(ctx, deliverCtx, p.lastHeader)
 
 			// module is shutting down
 			if err != nil && errors.Is(err, ctx.Err()) {
@@ -134,113 +618,1064 @@ func (p *EventPoller) Run(ctx context.Context) error {
 
 			// otherwise, log and continue
 			if err != nil {
-				log.Println("error polling events: %v", err)
-				// Skip updating latest so we don't lose events. The next run will backfill any
-				// missed blocks
+				p.log.Errorf("error polling events after height %d: %v", p.lastHeader.Height, err)
+				p.stats.setError(err, p.clock.Now())
+
+				consecutiveErrors++
+				if p.BackoffBase > 0 {
+					next = p.clock.After(p.backoff(consecutiveErrors))
+				}
+
+				// Ranges that were fully processed before the error are kept, so the next run
+				// backfills from the first missed block
 				continue
 			}
+			consecutiveErrors = 0
+
+			p.setLastHeader(newLatest)
+
+			if p.OnProgress != nil {
+				p.OnProgress(newLatest.Height)
+			}
 
-			p.lastHeader = newLatest
+			if p.reachedEndHeight() {
+				return nil
+			}
 		}
 	}
 }
 
+// backoff returns the wait before the next poll after the given number of consecutive errors. A
+// random jitter of up to half the wait is subtracted so multiple pollers don't retry in lockstep
+func (p *EventPoller) backoff(consecutiveErrors int) time.Duration {
+	factor := p.BackoffFactor
+	if factor <= 0 {
+		factor = 2
+	}
+
+	wait := float64(p.BackoffBase)
+	for i := 1; i < consecutiveErrors; i++ {
+		wait *= factor
+		if p.BackoffMax > 0 && wait >= float64(p.BackoffMax) {
+			break
+		}
+	}
+
+	if p.BackoffMax > 0 && wait > float64(p.BackoffMax) {
+		wait = float64(p.BackoffMax)
+	}
+
+	// avoid overflowing time.Duration when the wait isn't capped
+	if wait > float64(math.MaxInt64/2) {
+		wait = float64(math.MaxInt64 / 2)
+	}
+
+	jitter := rand.Int63n(int64(wait)/2 + 1)
+	return time.Duration(wait) - time.Duration(jitter)
+}
+
+func (p *EventPoller) setProcessingHeight(height uint64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.processingHeight = height
+}
+
+func (p *EventPoller) setLastHeader(header *flow.BlockHeader) {
+	p.headerMu.Lock()
+	defer p.headerMu.Unlock()
+
+	p.lastHeader = header
+}
+
+// reachedEndHeight returns true once all events up to EndHeight have been polled, including ranges
+// that failed to poll and are queried again
+func (p *EventPoller) reachedEndHeight() bool {
+	return p.EndHeight > 0 && p.lastHeader.Height >= p.EndHeight && !p.schedule.hasFailed()
+}
+
+// deliveryContext returns the context used when sending events to subscribers. When graceful
+// shutdown is enabled, it remains active for up to shutdownTimeout after ctx is cancelled
+func (p *EventPoller) deliveryContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if p.shutdownTimeout <= 0 {
+		return ctx, func() {}
+	}
+
+	deliverCtx, cancel := context.WithCancel(context.Background())
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-deliverCtx.Done():
+			return
+		}
+
+		select {
+		case <-p.clock.After(p.shutdownTimeout):
+			cancel()
+		case <-deliverCtx.Done():
+		}
+	}()
+
+	return deliverCtx, cancel
+}
+
+// startHeader returns the header of the last block considered processed before the first poll.
+// The first poll covers heights after it, so:
+//   - with a checkpoint, polling resumes after the saved height
+//   - with StartHeight, polling starts at StartHeight
+//   - with StartBlockID, polling starts at the block with that ID
+//   - with StartTime, polling starts at the first block at or after StartTime
+//   - otherwise, polling starts after the latest block
+//
+// Unless resuming from a checkpoint, polling starts earlier if a subscription was created with a
+// lower start height.
 func (p *EventPoller) startHeader(ctx context.Context) (*flow.BlockHeader, error) {
+	if p.checkpointer != nil {
+		height, err := p.checkpointer.Load(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("error loading checkpoint: %w", err)
+		}
+
+		if height > 0 {
+			return p.client.GetBlockHeaderByHeight(ctx, height)
+		}
+	}
+
+	header, err := p.configuredStartHeader(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var subStart uint64
+	for _, sub := range p.allSubscribers() {
+		if sub.startHeight > 0 && (subStart == 0 || sub.startHeight < subStart) {
+			subStart = sub.startHeight
+		}
+	}
+
+	if subStart > 0 && subStart-1 < header.Height {
+		// subscriptions without their own start height still start after the configured header
+		p.defaultStartHeight = addHeight(header.Height, 1)
+		return &flow.BlockHeader{Height: subStart - 1}, nil
+	}
+
+	return header, nil
+}
+
+// configuredStartHeader returns the start header based on StartHeight, StartBlockID and StartTime.
+// The start header is treated as already processed, so polling starts from the block after it.
+// Only its height is set when starting from a given block, since the block before it may be from
+// a previous spork, which the node doesn't serve
+func (p *EventPoller) configuredStartHeader(ctx context.Context) (*flow.BlockHeader, error) {
 	if p.StartHeight > 0 {
-		return p.client.GetBlockHeaderByHeight(ctx, p.StartHeight)
+		if err := p.waitForStartHeight(ctx); err != nil {
+			return nil, err
+		}
+		return &flow.BlockHeader{Height: p.StartHeight - 1}, nil
+	}
+
+	if p.StartBlockID != flow.EmptyID {
+		header, err := p.client.GetBlockHeaderByID(ctx, p.StartBlockID)
+		if err != nil {
+			return nil, fmt.Errorf("error getting start block %s: %w", p.StartBlockID, err)
+		}
+
+		// there's no block before the first block, so polling starts after it
+		if header.Height == 0 {
+			return header, nil
+		}
+		return &flow.BlockHeader{Height: header.Height - 1}, nil
+	}
+
+	if !p.StartTime.IsZero() {
+		return p.headerAtTime(ctx, p.StartTime)
 	}
 
-	return p.client.GetLatestBlockHeader(ctx, true)
+	return p.client.GetLatestBlockHeader(ctx, !p.FollowFinalized)
 }
 
-func (p *EventPoller) checkSubscriptions(ctx context.Context, lastHeader *flow.BlockHeader) (*flow.BlockHeader, error) {
-	latest, err := p.client.GetLatestBlockHeader(ctx, true)
+// waitForStartHeight checks that StartHeight is not after the latest block. If it is, it returns
+// ErrStartHeightInFuture, or waits for the chain to reach it when WaitForStartHeight is set
+func (p *EventPoller) waitForStartHeight(ctx context.Context) error {
+	for {
+		latest, err := p.client.GetLatestBlockHeader(ctx, !p.FollowFinalized)
+		if err != nil {
+			return fmt.Errorf("error getting latest header: %w", err)
+		}
+
+		if p.StartHeight <= latest.Height {
+			return nil
+		}
+
+		if !p.WaitForStartHeight {
+			return fmt.Errorf("%w: start height %d, latest height %d", ErrStartHeightInFuture, p.StartHeight, latest.Height)
+		}
+
+		p.log.Infof("waiting for the chain to reach start height %d, latest height %d", p.StartHeight, latest.Height)
 
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-p.clock.After(p.pollInterval()):
+		}
+	}
+}
+
+// headerAtTime returns the header of the block just before the first block with a timestamp at or
+// after t, so that polling starts with that block. It uses a binary search over block heights.
+// If t is before the oldest block the node serves, e.g. the root block of the current spork, or
+// after the latest block, the result is clamped to that block.
+func (p *EventPoller) headerAtTime(ctx context.Context, t time.Time) (*flow.BlockHeader, error) {
+	latest, err := p.client.GetLatestBlockHeader(ctx, !p.FollowFinalized)
 	if err != nil {
 		return nil, fmt.Errorf("error getting latest header: %w", err)
 	}
 
-	// nothing to do
-	if lastHeader.Height >= latest.Height {
+	if latest.Timestamp.Before(t) {
+		p.log.Errorf("start time %s is after the latest block %d (%s), starting from the latest block",
+			t, latest.Height, latest.Timestamp)
+		return latest, nil
+	}
+
+	low, err := p.searchLowerBound(ctx, t, latest.Height)
+	if err != nil {
+		return nil, fmt.Errorf("error searching for start time %s: %w", t, err)
+	}
+
+	// find the lowest height with a timestamp at or after t. Heights the node doesn't serve are
+	// all below the ones it does, so they're treated as before t
+	var searchErr error
+	height := low + uint64(sort.Search(int(latest.Height-low)+1, func(i int) bool {
+		if searchErr != nil {
+			return true
+		}
+
+		header, err := p.client.GetBlockHeaderByHeight(ctx, low+uint64(i))
+		if grpcCode(err) == codes.NotFound {
+			return false
+		}
+		if err != nil {
+			searchErr = err
+			return true
+		}
+
+		return !header.Timestamp.Before(t)
+	}))
+	if searchErr != nil {
+		return nil, fmt.Errorf("error searching for start time %s: %w", t, searchErr)
+	}
+
+	if height == 0 {
+		p.log.Errorf("start time %s is before the first block, starting from the first block", t)
+		return p.client.GetBlockHeaderByHeight(ctx, 0)
+	}
+
+	header, err := p.client.GetBlockHeaderByHeight(ctx, height-1)
+	if grpcCode(err) == codes.NotFound {
+		// only the height is needed to start polling from the oldest block
+		p.log.Errorf("start time %s is before the oldest available block %d, starting from that block", t, height)
+		return &flow.BlockHeader{Height: height - 1}, nil
+	}
+	return header, err
+}
+
+// searchLowerBound returns a height at or below the first block with a timestamp at or after t,
+// without going below the oldest block the node serves. It steps back from latest in doubling
+// steps, so the search range stays small when t is recent.
+func (p *EventPoller) searchLowerBound(ctx context.Context, t time.Time, latest uint64) (uint64, error) {
+	// step overflows to 0 after the highest power of 2
+	for step := uint64(1); step > 0 && step < latest; step *= 2 {
+		height := latest - step
+		header, err := p.client.GetBlockHeaderByHeight(ctx, height)
+		if grpcCode(err) == codes.NotFound {
+			return height + 1, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+
+		if header.Timestamp.Before(t) {
+			return height, nil
+		}
+	}
+	return 0, nil
+}
+
+// confirmedHeight returns the height ConfirmationDepth blocks behind height
+func (p *EventPoller) confirmedHeight(height uint64) uint64 {
+	if height < p.ConfirmationDepth {
+		return 0
+	}
+	return height - p.ConfirmationDepth
+}
+
+// latestHeader returns the header of the latest block to process, which is the latest sealed or
+// finalized block less ConfirmationDepth, capped at EndHeight
+func (p *EventPoller) latestHeader(ctx context.Context) (*flow.BlockHeader, error) {
+	latest, err := p.client.GetLatestBlockHeader(ctx, !p.FollowFinalized)
+	if err != nil {
+		return nil, err
+	}
+
+	height := p.confirmedHeight(latest.Height)
+	if p.EndHeight > 0 && height > p.EndHeight {
+		height = p.EndHeight
+	}
+
+	if height != latest.Height {
+		return p.client.GetBlockHeaderByHeight(ctx, height)
+	}
+
+	return latest, nil
+}
+
+func (p *EventPoller) checkSubscriptions(ctx, deliverCtx context.Context, lastHeader *flow.BlockHeader) (*flow.BlockHeader, error) {
+	// there's nothing to deliver without subscriptions, so no requests are sent until there are.
+	// The poller stays at the last processed height, so subscriptions created later receive events
+	// from after it
+	if !p.hasSubscriptions() {
+		p.schedule.retain(nil)
 		return lastHeader, nil
 	}
 
+	latest, err := p.latestHeader(ctx)
+
+	if err != nil {
+		err = fmt.Errorf("error getting latest header: %w", err)
+		if ctx.Err() == nil {
+			p.metrics.ObservePollError("")
+			p.notifyError(p.allSubscribers(), &SubscriptionError{
+				StartHeight: lastHeader.Height + 1,
+				Err:         err,
+			})
+		}
+		return nil, err
+	}
+
+	lastHeader, err = p.rewindNacked(ctx, lastHeader)
+	if err != nil {
+		return nil, err
+	}
+
+	// nothing to do, unless a range failed to poll, in which case it's queried again without waiting
+	// for new blocks
+	retry := lastHeader.Height == latest.Height && p.schedule.hasFailed()
+	if lastHeader.Height >= latest.Height && !retry {
+		p.metrics.ObserveLagHeight(0)
+		p.markCaughtUp()
+		return lastHeader, nil
+	}
+	p.metrics.ObserveLagHeight(latest.Height - lastHeader.Height)
+
+	if err := p.checkMaxLag(lastHeader, latest); err != nil {
+		return nil, err
+	}
+
+	if p.FollowFinalized {
+		lastHeader, err = p.checkReorg(ctx, deliverCtx, lastHeader)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	p.expandPatterns(ctx)
+
+	// when far behind, the chain may advance significantly while processing, so keep going until
+	// we're within one range of the tip instead of waiting for the next interval
+	catchingUp := latest.Height-lastHeader.Height > p.maxHeightRange()
+	if catchingUp {
+		p.behind = true
+	}
+
 	var header *flow.BlockHeader
+	cycleEvents := 0
 	for {
 		header = latest
 
 		// make sure the block range is not larger than the max, otherwise we'll need to break
 		// it up into multiple ranges
-		maxHeight := lastHeader.Height + DefaultMaxHeightRange
-		if latest.Height > maxHeight {
+		maxHeight := addHeight(lastHeader.Height, p.maxHeightRange())
+		if latest.Height > maxHeight && p.SkipHeaderLookups && !p.FollowFinalized {
+			// only the height is used, so there's no need to fetch the header
+			header = &flow.BlockHeader{Height: maxHeight}
+		} else if latest.Height > maxHeight {
 			header, err = p.client.GetBlockHeaderByHeight(ctx, maxHeight)
 			if err != nil {
-				return nil, fmt.Errorf("error getting header for height %d: %w", maxHeight, err)
+				err = fmt.Errorf("error getting header for height %d: %w", maxHeight, err)
+				if ctx.Err() == nil {
+					p.metrics.ObservePollError("")
+					p.notifyError(p.allSubscribers(), &SubscriptionError{
+						StartHeight: lastHeader.Height + 1,
+						EndHeight:   maxHeight,
+						Err:         err,
+					})
+				}
+				return nil, err
 			}
 		}
 
-		for eventSub := range p.subscriptions {
-			err = p.pollEvents(ctx, lastHeader.Height+1, header, eventSub)
+		p.setProcessingHeight(header.Height)
+
+		// events are held until the range is polled when limiting the events per cycle, so the
+		// range can end early once the limit is reached
+		limited := p.MaxEventsPerCycle > 0
+		batches := newBatchCollector(p.OrderedDelivery || limited)
+		if batches.ordered != nil && p.MaxBufferedEvents > 0 {
+			batches.limitHeld(p.MaxBufferedEvents, lastHeader.Height+1)
+		}
+		prevCheckpoint := p.checkpointHeight(lastHeader.Height)
+		failed := make(map[string]bool)
+		errs, deferred := p.pollAllEvents(ctx, deliverCtx, lastHeader.Height+1, header, batches)
+		if end := batches.endHeight(header.Height); end < header.Height {
+			p.log.Debugf("more than %d events held, ending the range at height %d", p.MaxBufferedEvents, end)
+			header, err = p.truncateRange(ctx, end, batches)
 			if err != nil {
-				// module is shutting down
-				if ctx.Err() != nil {
-					return nil, ctx.Err()
-				}
+				return nil, err
+			}
+		}
+		for eventSub, err := range errs {
+			// module is shutting down
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
 
-				log.Printf("error polling events %s for %d - %d: %v", eventSub, lastHeader.Height+1, header.Height, err)
-				if p.PollingErrorBehavior == ErrorBehaviorStop {
-					return nil, ErrAbort
-				}
+			// the range that failed may start before this range, if it's queried again after a
+			// previous failure
+			startHeight := lastHeader.Height + 1
+			if polled, ok := p.schedule.failedHeight(eventSub); ok && polled < lastHeader.Height {
+				startHeight = polled + 1
+			}
+
+			subs := p.subscribers(eventSub)
+			subErr := &SubscriptionError{
+				EventType:       eventSub,
+				StartHeight:     startHeight,
+				EndHeight:       header.Height,
+				SubscriptionIDs: subscriptionIDs(subs),
+				Terminal:        p.PollingErrorBehavior == ErrorBehaviorStop,
+				Err:             err,
+			}
+
+			p.metrics.ObservePollError(eventSub)
+			p.log.Errorf("%v (subscriptions: %s)", subErr, strings.Join(subErr.SubscriptionIDs, ", "))
+			p.stats.setError(subErr, p.clock.Now())
+
+			if p.PollingErrorBehavior == ErrorBehaviorStop {
+				// all subscriptions stop receiving events, not just the ones for this type
+				p.notifyError(p.allSubscribers(), subErr)
+				return nil, ErrAbort
 			}
+
+			p.notifyError(subs, subErr)
+			failed[eventSub] = true
+		}
+		for _, eventType := range deferred {
+			failed[eventType] = true
 		}
 
-		if header.Height == latest.Height {
+		if limited {
+			header, err = p.deliverLimited(ctx, deliverCtx, lastHeader.Height, header, batches, p.MaxEventsPerCycle-cycleEvents)
+			if err != nil {
+				return nil, err
+			}
+		} else if batches.ordered != nil {
+			p.deliverEvents(deliverCtx, batches.orderedEvents(), batches)
+		}
+		p.deliverBatches(deliverCtx, batches)
+
+		// module is shutting down. When every event type was polled for the whole range, and the
+		// graceful shutdown delivered all of its events, the range's progress is saved first so
+		// it's not delivered again after a restart
+		shutdown := ctx.Err() != nil
+		if shutdown && deliverCtx.Err() != nil {
+			return nil, ctx.Err()
+		}
+
+		if err := p.checkHandlerErrors(); err != nil {
+			return nil, err
+		}
+
+		completed := p.updateWatermarks(header.Height, failed)
+		if p.DeliverEmptyRanges {
+			p.deliverMarkers(deliverCtx, header, completed)
+		}
+		p.trackBlock(header.Height, header.ID)
+		p.metrics.ObserveBlocksPolled(header.Height - lastHeader.Height)
+		p.stats.addBlocks(header.Height - lastHeader.Height)
+
+		// save progress after each range, so a restart during a long backfill only repeats the
+		// range that was in progress, and any range that failed to poll
+		p.saveCheckpoint(deliverCtx, prevCheckpoint, header.Height)
+		p.setLastHeader(header)
+
+		if shutdown {
+			return nil, ctx.Err()
+		}
+
+		// only ranges with new blocks are reported, not failed ranges queried again at the tip
+		if p.OnRangeProcessed != nil && header.Height > lastHeader.Height {
+			p.OnRangeProcessed(lastHeader.Height+1, header.Height, batches.counts)
+		}
+
+		cycleEvents += batches.deliveredCount()
+		if limited && cycleEvents >= p.MaxEventsPerCycle && (header.Height < latest.Height || catchingUp) {
+			p.log.Debugf("delivered %d events this cycle, continuing after height %d next cycle", cycleEvents, header.Height)
 			break
 		}
 
+		if header.Height == latest.Height {
+			if !catchingUp {
+				p.markCaughtUp()
+				break
+			}
+
+			newLatest, err := p.latestHeader(ctx)
+			if err != nil {
+				// progress was made, so the next cycle will pick up from here
+				p.log.Errorf("error getting latest header while catching up: %v", err)
+				break
+			}
+
+			if newLatest.Height <= header.Height {
+				p.markCaughtUp()
+				break
+			}
+
+			catchingUp = newLatest.Height-header.Height > p.maxHeightRange()
+			latest = newLatest
+		}
+
 		lastHeader = header
 	}
 
 	return header, nil
 }
 
-func (p *EventPoller) pollEvents(ctx context.Context, startHeight uint64, header *flow.BlockHeader, eventType string) error {
-	blockEvents, err := p.client.GetEventsForHeightRange(ctx, client.EventRangeQuery{
-		Type:        eventType,
-		StartHeight: startHeight,
-		EndHeight:   header.Height,
+// deliverLimited delivers the polled events block by block until at least limit events have been
+// delivered, and returns the header of the last block delivered. The rest of the range is left for
+// the next cycle, and is polled again then. Blocks are delivered whole, so the limit may be exceeded
+// by the events of the last block. Events from before the range, e.g. from a failed range queried
+// again, are delivered without ending the range, since it can't end before lastHeight
+func (p *EventPoller) deliverLimited(ctx, deliverCtx context.Context, lastHeight uint64, header *flow.BlockHeader, batches *batchCollector, limit int) (*flow.BlockHeader, error) {
+	blockEvents := batches.orderedEvents()
+	for i := range blockEvents {
+		p.deliverEvents(deliverCtx, blockEvents[i:i+1], batches)
+
+		height := blockEvents[i].Height
+		if batches.deliveredCount() < limit || height <= lastHeight || height >= header.Height || deliverCtx.Err() != nil {
+			continue
+		}
+
+		return p.truncateRange(ctx, height, batches)
+	}
+
+	return header, nil
+}
+
+// truncateRange ends the range being polled at height, and returns its header. The rest of the
+// range is left for the next range, and is polled again then
+func (p *EventPoller) truncateRange(ctx context.Context, height uint64, batches *batchCollector) (*flow.BlockHeader, error) {
+	// only the height is needed unless block IDs are tracked to detect reorgs
+	truncated := &flow.BlockHeader{Height: height}
+	if !p.SkipHeaderLookups || p.FollowFinalized {
+		var err error
+		truncated, err = p.client.GetBlockHeaderByHeight(ctx, height)
+		if err != nil {
+			return nil, fmt.Errorf("error getting header for height %d: %w", height, err)
+		}
+	}
+
+	// event types queried on their own interval were polled past the new end of the range
+	p.schedule.rewind(height)
+	batches.recount(height)
+	return truncated, nil
+}
+
+// checkMaxLag applies MaxLagBehavior when the poller is more than MaxLagBlocks behind latest
+func (p *EventPoller) checkMaxLag(lastHeader, latest *flow.BlockHeader) error {
+	lag := latest.Height - lastHeader.Height
+	if p.MaxLagBlocks == 0 || lag <= p.MaxLagBlocks {
+		return nil
+	}
+
+	p.log.Errorf("poller is %d blocks behind the latest block %d, more than the max of %d", lag, latest.Height, p.MaxLagBlocks)
+	if p.OnMaxLagExceeded != nil {
+		p.OnMaxLagExceeded(lag)
+	}
+
+	if p.MaxLagBehavior != ErrorBehaviorStop {
+		return nil
+	}
+
+	p.notifyError(p.allSubscribers(), &SubscriptionError{
+		StartHeight: lastHeader.Height + 1,
+		EndHeight:   latest.Height,
+		Terminal:    true,
+		Err:         fmt.Errorf("lag of %d blocks exceeds the max of %d", lag, p.MaxLagBlocks),
 	})
+	return ErrAbort
+}
+
+// checkpointHeight returns the height to save as the checkpoint once all ranges up to height are
+// processed. Event types queried on their own interval, or with a range that failed to poll, may not
+// have been queried up to height yet, so the lowest height all event types have been queried up to
+// is used instead. A restart then queries the failed range again
+func (p *EventPoller) checkpointHeight(height uint64) uint64 {
+	checkpoint := p.schedule.lowestHeight(height)
+	if p.AckMode {
+		checkpoint = p.acks.lowestHeight(checkpoint)
+	}
+	return checkpoint
+}
+
+// saveCheckpoint saves the checkpoint for height with the checkpointer if it's after prevCheckpoint
+func (p *EventPoller) saveCheckpoint(ctx context.Context, prevCheckpoint, height uint64) {
+	if p.checkpointer == nil {
+		return
+	}
+
+	checkpoint := p.checkpointHeight(height)
+	if checkpoint <= prevCheckpoint {
+		return
+	}
+
+	if err := p.checkpointer.Save(ctx, checkpoint); err != nil {
+		p.log.Errorf("error saving checkpoint: %v", err)
+	}
+}
+
+func (p *EventPoller) maxHeightRange() uint64 {
+	if p.MaxHeightRange == 0 {
+		return DefaultMaxHeightRange
+	}
+	return p.MaxHeightRange
+}
+
+// addHeight returns height+n, saturating at math.MaxUint64 instead of wrapping around
+func addHeight(height, n uint64) uint64 {
+	if height > math.MaxUint64-n {
+		return math.MaxUint64
+	}
+	return height + n
+}
+
+// pollAllEvents polls all subscribed event types for a height range, querying up to
+// PollConcurrency event types at a time. It returns the errors encountered by event type, and the
+// event types that were not queried because they're not due yet. When configured to stop on
+// errors, no new queries are started after the first error.
+//
+// When there are subscriptions to all events, the events of other types are fetched block by block
+// after the subscribed types are queried. Errors doing so are returned for allEvents.
+func (p *EventPoller) pollAllEvents(ctx, deliverCtx context.Context, startHeight uint64, header *flow.BlockHeader, batches *batchCollector) (map[string]error, []string) {
+	concurrency := p.PollConcurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	errs := make(map[string]error)
+	sem := make(chan struct{}, concurrency)
+
+	var deferred []string
+	now := p.clock.Now()
+	eventTypes := p.subscribedEvents()
+	p.schedule.retain(eventTypes)
+	for _, eventType := range eventTypes {
+		if eventType == allEvents {
+			continue
+		}
+
+		typeStart := startHeight
+		interval := p.typeInterval(eventType)
+		due, polledStart := p.schedule.due(eventType, now)
+		if interval > 0 && !due {
+			deferred = append(deferred, eventType)
+			continue
+		}
+
+		// event types queried on their own interval, or with a range that failed to poll, resume
+		// after the last height they were queried up to
+		if polledStart > 0 {
+			typeStart = polledStart
+		}
+
+		// only failed ranges are queried when they're queried again at the tip
+		if typeStart > header.Height {
+			continue
+		}
+
+		// versioned types are only queried within the heights they're used in
+		typeStart, typeEnd, ok := p.typeHeights(eventType, typeStart, header.Height)
+		if !ok {
+			p.schedulePolled(eventType, header.Height, now, interval)
+			continue
+		}
+
+		sem <- struct{}{}
+
+		mu.Lock()
+		abort := len(errs) > 0 && p.PollingErrorBehavior == ErrorBehaviorStop
+		mu.Unlock()
+
+		if abort || ctx.Err() != nil {
+			// the range isn't complete when the module shuts down before all types are queried
+			if ctx.Err() != nil {
+				mu.Lock()
+				errs[eventType] = ctx.Err()
+				mu.Unlock()
+			}
+			<-sem
+			break
+		}
+
+		if p.MaxBufferedEvents > 0 {
+			p.buffered.wait(p.MaxBufferedEvents)
+		}
+
+		wg.Add(1)
+		go func(eventType string, typeStart, typeEnd uint64, interval time.Duration) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			// event types queried on their own interval may be behind by more than one range
+			polled := typeStart - 1
+			err := p.forEachChunk(typeStart, typeEnd, func(chunkStart, chunkEnd uint64) error {
+				// the range may have been shrunk to limit the events held
+				chunkEnd = batches.endHeight(chunkEnd)
+				if chunkStart > chunkEnd {
+					return nil
+				}
+
+				if err := p.pollEvents(ctx, deliverCtx, chunkStart, chunkEnd, eventType, batches); err != nil {
+					return err
+				}
+				polled = chunkEnd
+				return nil
+			})
+			if err != nil {
+				mu.Lock()
+				errs[eventType] = err
+				mu.Unlock()
+
+				// the rest of the range is queried again with the next range, so the type's
+				// subscriptions don't miss its events
+				p.schedule.failed(eventType, polled)
+				return
+			}
+
+			p.schedulePolled(eventType, header.Height, now, interval)
+		}(eventType, typeStart, typeEnd, interval)
+	}
+	wg.Wait()
+
+	if !p.hasAllSubscription() || (len(errs) > 0 && p.PollingErrorBehavior == ErrorBehaviorStop) {
+		return errs, deferred
+	}
+	if ctx.Err() != nil {
+		errs[allEvents] = ctx.Err()
+		return errs, deferred
+	}
+
+	// events can only be queried by type, so the rest of the events are fetched block by block.
+	// Subscriptions to all events already received the subscribed types from their own queries,
+	// which also include events from the system chunk
+	queried := make(map[string]bool, len(eventTypes))
+	for _, eventType := range eventTypes {
+		queried[eventType] = true
+	}
+
+	bulkStart := startHeight
+	if _, polledStart := p.schedule.due(allEvents, now); polledStart > 0 {
+		bulkStart = polledStart
+	}
+	polled, err := p.pollBulk(ctx, deliverCtx, bulkStart, header, batches, queried)
+	if err != nil {
+		errs[allEvents] = err
+		p.schedule.failed(allEvents, polled)
+	} else {
+		p.schedule.remove(allEvents)
+	}
+
+	return errs, deferred
+}
+
+// schedulePolled records that the event type was queried up to height. Event types queried every
+// cycle are no longer tracked, since they're caught up
+func (p *EventPoller) schedulePolled(eventType string, height uint64, now time.Time, interval time.Duration) {
+	if interval > 0 {
+		p.schedule.polled(eventType, height, now.Add(interval))
+	} else {
+		p.schedule.remove(eventType)
+	}
+}
+
+// updateWatermarks advances the watermark of all subscriptions to height, except for those that
+// receive an event type that failed to poll or was not queried. Their watermark stays below the
+// missed range until it's queried again. It returns the subscriptions whose watermark advanced
+func (p *EventPoller) updateWatermarks(height uint64, failed map[string]bool) []*Subscription {
+	incomplete := make(map[*Subscription]bool)
+	for eventType := range failed {
+		for _, sub := range p.subscribers(eventType) {
+			incomplete[sub] = true
+		}
+	}
+
+	var completed []*Subscription
+	for _, sub := range p.allSubscribers() {
+		if incomplete[sub] || sub.Watermark() >= height {
+			continue
+		}
+
+		atomic.StoreUint64(&sub.watermark, height)
+		completed = append(completed, sub)
+	}
+	return completed
+}
+
+func (p *EventPoller) pollEvents(ctx, deliverCtx context.Context, startHeight, endHeight uint64, eventType string, batches *batchCollector) error {
+	// the node may report a lower latest height than previously seen, e.g. after a failover to a
+	// node that's behind. There's nothing to poll until it catches up
+	if startHeight > endHeight {
+		p.log.Debugf("skipping %s events for inverted range %d-%d", eventType, startHeight, endHeight)
+		return nil
+	}
+
+	blockEvents, err := p.queryEvents(ctx, eventType, startHeight, endHeight)
 	if err != nil {
 		return err
 	}
+	blockEvents = p.routeEventTypes(eventType, blockEvents, batches)
+	batches.count(blockEvents)
+
+	if batches.ordered != nil {
+		batches.addBlockEvents(blockEvents)
+		return nil
+	}
+
+	count := 0
+	for _, be := range blockEvents {
+		count += len(be.Events)
+	}
+	p.buffered.add(count)
+	defer p.buffered.release(count)
+
+	p.deliverEvents(deliverCtx, blockEvents, batches)
+	return nil
+}
+
+// routeEventTypes makes sure events returned for a query are delivered to the queried type's
+// subscriptions. Access nodes may return events with a type that differs from the query, e.g. in
+// casing, which would otherwise not match any subscription. Those events are delivered unchanged
+// to the queried type's subscriptions. Events of types that are also queried on their own are
+// removed instead, since they're delivered from their own query
+func (p *EventPoller) routeEventTypes(eventType string, blockEvents []client.BlockEvents, batches *batchCollector) []client.BlockEvents {
+	var subscribed map[string]bool
+	for i, be := range blockEvents {
+		events := be.Events[:0]
+		for _, event := range be.Events {
+			if event.Type == eventType || batches.routeType(event.Type) != "" {
+				events = append(events, event)
+				continue
+			}
+
+			if subscribed == nil {
+				subscribed = make(map[string]bool)
+				for _, t := range p.subscribedEvents() {
+					subscribed[t] = true
+				}
+			}
+			if subscribed[event.Type] {
+				continue
+			}
+
+			p.log.Errorf("query for %s events returned a %s event at height %d, delivering it to the %s subscriptions",
+				eventType, event.Type, be.Height, eventType)
+			batches.route(event.Type, eventType)
+			events = append(events, event)
+		}
+		blockEvents[i].Events = events
+	}
+	return blockEvents
+}
+
+// deliverMarkers sends a marker to each of subs, which have received all events up to header
+func (p *EventPoller) deliverMarkers(deliverCtx context.Context, header *flow.BlockHeader, subs []*Subscription) {
+	for _, sub := range subs {
+		if sub.Channel == nil {
+			continue
+		}
+
+		marker := &BlockEvent{
+			BlockHeight:    header.Height,
+			BlockTimestamp: header.Timestamp,
+			Complete:       true,
+		}
+		if !p.deliver(deliverCtx, sub, marker) {
+			return
+		}
+	}
+}
+
+// sortBlockEvents sorts blocks by height, and removes repeated blocks, since not all access node
+// implementations guarantee the order of the results
+func sortBlockEvents(blockEvents []client.BlockEvents) []client.BlockEvents {
+	sort.SliceStable(blockEvents, func(i, j int) bool {
+		return blockEvents[i].Height < blockEvents[j].Height
+	})
+
+	unique := blockEvents[:0]
+	for i, be := range blockEvents {
+		if i > 0 && be.Height == blockEvents[i-1].Height {
+			continue
+		}
+		unique = append(unique, be)
+	}
+	return unique
+}
+
+// deliverEvents sends events to their subscribers. Events for batch subscriptions are added to
+// batches, and delivered once the whole range is polled
+func (p *EventPoller) deliverEvents(deliverCtx context.Context, blockEvents []client.BlockEvents, batches *batchCollector) {
+	delivered := make(map[string]int)
+	defer func() {
+		for eventType, count := range delivered {
+			p.metrics.ObserveEventsDelivered(eventType, count)
+			p.stats.addEvents(eventType, count)
+			batches.addDelivered(count)
+		}
+	}()
+
+	// when fanning out, events are grouped by subscription and delivered to each subscription
+	// concurrently once all events are processed
+	fanOut := p.FanOutConcurrency > 1
+	var pending map[*Subscription][]*BlockEvent
+	var pendingSubs []*Subscription
+	if fanOut {
+		pending = make(map[*Subscription][]*BlockEvent)
+		defer func() {
+			p.fanOut(deliverCtx, pendingSubs, pending, delivered)
+		}()
+	}
 
 	// sent notifications for events
 	for _, be := range blockEvents {
+		p.trackBlock(be.Height, be.BlockID)
+
 		for _, event := range be.Events {
 			event := event
-			for _, sub := range p.subscriptions[event.Type] {
+			if p.dedup != nil && p.dedup.seenBefore(be.Height, &event) {
+				continue
+			}
+
+			// events returned with a different type than queried are delivered to the queried
+			// type's subscriptions
+			eventType := event.Type
+			if routed := batches.routeType(eventType); routed != "" {
+				eventType = routed
+			}
+
+			payload := newEventPayload(&event)
+			matched := false
+			for _, sub := range p.subscribers(eventType) {
+				if be.Height < sub.startHeight || (sub.startHeight == 0 && be.Height < p.defaultStartHeight) {
+					continue
+				}
+
+				if !sub.inHeightRange(eventType, be.Height) {
+					continue
+				}
+
+				if sub.filter != nil && !sub.filter(&event) {
+					continue
+				}
+				if !matched {
+					matched = true
+					p.recordRecent(be, &event, payload)
+				}
+
+				if sub.Batches != nil || sub.Transactions != nil || sub.BlockEvents != nil {
+					batches.add(sub, be, event)
+					delivered[event.Type]++
+					continue
+				}
+
 				subEvent := &BlockEvent{
-					Event: &event,
+					Event:            &event,
+					BlockHeight:      be.Height,
+					BlockTimestamp:   be.BlockTimestamp,
+					TransactionIndex: event.TransactionIndex,
+					EventIndex:       event.EventIndex,
+					payload:          payload,
+				}
+				if p.AckMode {
+					subEvent.ack = p.acks.track(be.Height)
 				}
 
-				select {
-				case <-ctx.Done():
-					return nil
-				case sub.Channel <- subEvent:
+				if fanOut {
+					if _, ok := pending[sub]; !ok {
+						pendingSubs = append(pendingSubs, sub)
+					}
+					pending[sub] = append(pending[sub], subEvent)
+					continue
+				}
+
+				if !p.deliver(deliverCtx, sub, subEvent) {
+					return
 				}
+				delivered[event.Type]++
 			}
 		}
 	}
-	return nil
 }
 
-func randomString(n int) string {
-	var letters = []rune("abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789")
-
-	s := make([]rune, n)
-	for i := range s {
-		s[i] = letters[rand.Intn(len(letters))]
+// recordRecent adds an event to the recent events buffer, if enabled
+func (p *EventPoller) recordRecent(be client.BlockEvents, event *flow.Event, payload *eventPayload) {
+	if p.recent == nil {
+		return
 	}
 
-	return string(s)
+	p.recent.add(&BlockEvent{
+		Event:            event,
+		BlockHeight:      be.Height,
+		BlockTimestamp:   be.BlockTimestamp,
+		TransactionIndex: event.TransactionIndex,
+		EventIndex:       event.EventIndex,
+		payload:          payload,
+	})
+}
+
+// fanOut delivers each subscription's events in order, delivering to up to FanOutConcurrency
+// subscriptions at a time, so a slow subscription doesn't delay the others. It returns once all
+// events are delivered, or the context is cancelled.
+func (p *EventPoller) fanOut(ctx context.Context, subs []*Subscription, events map[*Subscription][]*BlockEvent, delivered map[string]int) {
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, p.FanOutConcurrency)
+
+	for _, sub := range subs {
+		select {
+		case <-ctx.Done():
+			wg.Wait()
+			return
+		case sem <- struct{}{}:
+		}
+
+		wg.Add(1)
+		go func(sub *Subscription) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			for _, event := range events[sub] {
+				if !p.deliver(ctx, sub, event) {
+					return
+				}
+
+				mu.Lock()
+				delivered[event.Event.Type]++
+				mu.Unlock()
+			}
+		}(sub)
+	}
+	wg.Wait()
 }
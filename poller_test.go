@@ -0,0 +1,516 @@
+package poller
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/onflow/flow-go-sdk"
+	"github.com/onflow/flow-go-sdk/client"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const testEvent = "A.0000000000000001.Test.Event"
+
+func TestMaxHeightRangeChunksQueries(t *testing.T) {
+	c := newFakeClient(35)
+	p := newTestPoller(c)
+	p.MaxHeightRange = 10
+	p.Subscribe([]string{testEvent}, WithBufferSize(10))
+
+	if height := poll(t, p, 0); height != 35 {
+		t.Fatalf("expected to poll up to height 35, got %d", height)
+	}
+
+	var ranges [][2]uint64
+	for _, query := range c.eventQueries() {
+		ranges = append(ranges, [2]uint64{query.StartHeight, query.EndHeight})
+	}
+
+	expected := [][2]uint64{{1, 10}, {11, 20}, {21, 30}, {31, 35}}
+	if !reflect.DeepEqual(ranges, expected) {
+		t.Fatalf("expected queries for ranges %v, got %v", expected, ranges)
+	}
+}
+
+func TestPollEventsSortsBlocks(t *testing.T) {
+	c := newFakeClient(5)
+	c.addEvent(testEvent, 2)
+	c.addEvent(testEvent, 3)
+	c.addEvent(testEvent, 5)
+
+	// return the blocks in reverse order, with the last block repeated
+	c.transform = func(_ client.EventRangeQuery, blockEvents []client.BlockEvents) []client.BlockEvents {
+		reversed := make([]client.BlockEvents, 0, len(blockEvents)+1)
+		for i := len(blockEvents) - 1; i >= 0; i-- {
+			reversed = append(reversed, blockEvents[i])
+		}
+		return append(reversed, blockEvents[len(blockEvents)-1])
+	}
+
+	p := newTestPoller(c)
+	sub := p.Subscribe([]string{testEvent}, WithBufferSize(10))
+	poll(t, p, 0)
+
+	if got := heights(receive(sub)); !reflect.DeepEqual(got, []uint64{2, 3, 5}) {
+		t.Fatalf("expected events at heights [2 3 5], got %v", got)
+	}
+}
+
+func TestNodeReportsLowerLatestHeight(t *testing.T) {
+	c := newFakeClient(20)
+	c.addEvent(testEvent, 15)
+
+	p := newTestPoller(c)
+	sub := p.Subscribe([]string{testEvent}, WithBufferSize(10))
+	poll(t, p, 10)
+
+	// fail over to a node that's behind the heights already processed
+	c.setLatest(8)
+	if height := poll(t, p, 20); height != 20 {
+		t.Fatalf("expected last processed height to stay at 20, got %d", height)
+	}
+
+	// polling an inverted range is skipped instead of sending the request
+	ctx := context.Background()
+	if err := p.pollEvents(ctx, ctx, 21, 8, testEvent, newBatchCollector(false)); err != nil {
+		t.Fatalf("unexpected error polling inverted range: %v", err)
+	}
+
+	if queries := c.eventQueries(); len(queries) != 1 {
+		t.Fatalf("expected 1 event query, got %d: %v", len(queries), queries)
+	}
+	if got := heights(receive(sub)); !reflect.DeepEqual(got, []uint64{15}) {
+		t.Fatalf("expected events at heights [15], got %v", got)
+	}
+}
+
+func TestResumeAfterInterruptedRange(t *testing.T) {
+	c := newFakeClient(50)
+	for height := uint64(1); height <= 50; height++ {
+		c.addEvent(testEvent, height)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// the process is stopped while the fourth range is polled
+	c.onEvents = func(query client.EventRangeQuery) error {
+		if query.StartHeight == 31 {
+			cancel()
+			return ctx.Err()
+		}
+		return nil
+	}
+
+	checkpointer := NewMemoryCheckpointer(0)
+	p := newTestPoller(c, WithCheckpointer(checkpointer))
+	p.MaxHeightRange = 10
+	sub := p.Subscribe([]string{testEvent}, WithBufferSize(50))
+
+	if _, err := p.checkSubscriptions(ctx, ctx, &flow.BlockHeader{Height: 0}); err == nil {
+		t.Fatal("expected polling to be interrupted")
+	}
+
+	saved, _ := checkpointer.Load(ctx)
+	if saved != 30 {
+		t.Fatalf("expected checkpoint at height 30, got %d", saved)
+	}
+	if got := len(receive(sub)); got != 30 {
+		t.Fatalf("expected 30 events before the interruption, got %d", got)
+	}
+
+	// a new poller resumes from the checkpoint, only repeating the interrupted range
+	c.onEvents = nil
+	c.queries = nil
+
+	resumed := newTestPoller(c, WithCheckpointer(checkpointer))
+	resumed.MaxHeightRange = 10
+	sub = resumed.Subscribe([]string{testEvent}, WithBufferSize(50))
+
+	poll(t, resumed, start(t, resumed))
+
+	queries := c.eventQueries()
+	if len(queries) == 0 || queries[0].StartHeight != 31 {
+		t.Fatalf("expected polling to resume at height 31, got queries %v", queries)
+	}
+	if got := heights(receive(sub)); len(got) != 20 || got[0] != 31 || got[19] != 50 {
+		t.Fatalf("expected events at heights 31-50 after resuming, got %v", got)
+	}
+}
+
+func TestCheckpointHeldBelowFailedRange(t *testing.T) {
+	c := newFakeClient(20)
+	c.addEvent(testEvent, 5)
+	c.addEvent(testEvent, 15)
+
+	failing := true
+	c.onEvents = func(query client.EventRangeQuery) error {
+		if query.StartHeight == 1 && failing {
+			return status.Error(codes.Unavailable, "unavailable")
+		}
+		return nil
+	}
+
+	checkpointer := NewMemoryCheckpointer(0)
+	p := newTestPoller(c, WithCheckpointer(checkpointer))
+	p.StartHeight = 1
+	p.MaxHeightRange = 10
+	p.Subscribe([]string{testEvent}, WithBufferSize(10))
+
+	if height := poll(t, p, start(t, p)); height != 20 {
+		t.Fatalf("expected to poll up to height 20, got %d", height)
+	}
+
+	ctx := context.Background()
+	if saved, _ := checkpointer.Load(ctx); saved != 0 {
+		t.Fatalf("expected no checkpoint while range 1-10 is failing, got %d", saved)
+	}
+
+	// a restart polls the failed range again
+	restarted := newTestPoller(c, WithCheckpointer(checkpointer))
+	restarted.StartHeight = 1
+	if height := start(t, restarted); height != 0 {
+		t.Fatalf("expected a restart to poll from height 1, got start header at height %d", height)
+	}
+
+	// once the failed range is polled, the checkpoint advances, even without new blocks
+	failing = false
+	poll(t, p, 20)
+
+	if saved, _ := checkpointer.Load(ctx); saved != 20 {
+		t.Fatalf("expected checkpoint at height 20, got %d", saved)
+	}
+}
+
+func TestGracefulShutdownSavesDeliveredRange(t *testing.T) {
+	c := newFakeClient(10)
+	c.addEvent(testEvent, 5)
+
+	checkpointer := NewMemoryCheckpointer(0)
+	p := newTestPoller(c, WithCheckpointer(checkpointer))
+	sub := p.Subscribe([]string{testEvent}, WithBufferSize(10))
+
+	// the module shuts down after the range is fetched, while its events are being delivered
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	c.transform = func(_ client.EventRangeQuery, blockEvents []client.BlockEvents) []client.BlockEvents {
+		cancel()
+		return blockEvents
+	}
+
+	_, err := p.checkSubscriptions(ctx, context.Background(), &flow.BlockHeader{ID: fakeID(0), Height: 0})
+	if err != context.Canceled {
+		t.Fatalf("expected the shutdown error, got %v", err)
+	}
+
+	if got := heights(receive(sub)); !reflect.DeepEqual(got, []uint64{5}) {
+		t.Fatalf("expected events at heights [5], got %v", got)
+	}
+	if saved, _ := checkpointer.Load(context.Background()); saved != 10 {
+		t.Fatalf("expected checkpoint at height 10, got %d", saved)
+	}
+	if watermark := sub.Watermark(); watermark != 10 {
+		t.Fatalf("expected watermark at height 10, got %d", watermark)
+	}
+	if p.lastHeader.Height != 10 {
+		t.Fatalf("expected last height 10, got %d", p.lastHeader.Height)
+	}
+}
+
+func TestSubscribeWhileRunning(t *testing.T) {
+	c := newFakeClient(20)
+	c.addEvent(testEvent, 5)
+	c.addEvent(testEvent, 15)
+
+	p := newTestPoller(c)
+	p.MaxHeightRange = 10
+	sub := p.Subscribe([]string{testEvent}, WithBufferSize(10))
+
+	// subscribe while the first range is being polled
+	var late *Subscription
+	c.onEvents = func(query client.EventRangeQuery) error {
+		if late == nil {
+			late = p.Subscribe([]string{testEvent}, WithBufferSize(10))
+		}
+		return nil
+	}
+
+	poll(t, p, 0)
+
+	if late.StartHeight() != 11 {
+		t.Fatalf("expected the new subscription to start at height 11, got %d", late.StartHeight())
+	}
+	if got := heights(receive(sub)); !reflect.DeepEqual(got, []uint64{5, 15}) {
+		t.Fatalf("expected events at heights [5 15], got %v", got)
+	}
+	if got := heights(receive(late)); !reflect.DeepEqual(got, []uint64{15}) {
+		t.Fatalf("expected the new subscription to receive events at heights [15], got %v", got)
+	}
+}
+
+// start returns the height of the poller's start header, which is treated as already processed
+func start(t *testing.T, p *EventPoller) uint64 {
+	t.Helper()
+
+	header, err := p.startHeader(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error getting start header: %v", err)
+	}
+	return header.Height
+}
+
+func TestStartHeightIsInclusive(t *testing.T) {
+	c := newFakeClient(10)
+	for height := uint64(3); height <= 6; height++ {
+		c.addEvent(testEvent, height)
+	}
+
+	p := newTestPoller(c)
+	p.StartHeight = 5
+	sub := p.Subscribe([]string{testEvent}, WithBufferSize(10))
+	early := p.Subscribe([]string{testEvent}, WithBufferSize(10), WithStartHeight(4))
+
+	poll(t, p, start(t, p))
+
+	if got := heights(receive(sub)); !reflect.DeepEqual(got, []uint64{5, 6}) {
+		t.Fatalf("expected events at heights [5 6], got %v", got)
+	}
+	if got := heights(receive(early)); !reflect.DeepEqual(got, []uint64{4, 5, 6}) {
+		t.Fatalf("expected events at heights [4 5 6] for the subscription's start height, got %v", got)
+	}
+}
+
+func TestStartBlockIDIsInclusive(t *testing.T) {
+	c := newFakeClient(10)
+	c.addEvent(testEvent, 4)
+	c.addEvent(testEvent, 5)
+
+	p := newTestPoller(c)
+	p.StartBlockID = fakeID(5)
+	sub := p.Subscribe([]string{testEvent}, WithBufferSize(10))
+
+	poll(t, p, start(t, p))
+
+	if got := heights(receive(sub)); !reflect.DeepEqual(got, []uint64{5}) {
+		t.Fatalf("expected events at heights [5], got %v", got)
+	}
+}
+
+func TestStartHeightAtSporkRoot(t *testing.T) {
+	for _, followFinalized := range []bool{false, true} {
+		c := newFakeClient(110)
+		c.root = 100
+		c.addEvent(testEvent, 100)
+		c.addEvent(testEvent, 105)
+
+		p := newTestPoller(c)
+		p.StartHeight = 100
+		p.FollowFinalized = followFinalized
+		sub := p.Subscribe([]string{testEvent}, WithBufferSize(10))
+
+		if height := start(t, p); height != 99 {
+			t.Fatalf("expected the start header at height 99, got %d", height)
+		}
+
+		ctx := context.Background()
+		header, err := p.checkSubscriptions(ctx, ctx, &flow.BlockHeader{Height: 99})
+		if err != nil {
+			t.Fatalf("unexpected error polling from the spork root (follow finalized: %v): %v", followFinalized, err)
+		}
+		if header.Height != 110 {
+			t.Fatalf("expected to poll up to height 110, got %d", header.Height)
+		}
+
+		if got := heights(receive(sub)); !reflect.DeepEqual(got, []uint64{100, 105}) {
+			t.Fatalf("expected events at heights [100 105], got %v", got)
+		}
+	}
+}
+
+func TestStartTimeWithHistoryFromSporkRoot(t *testing.T) {
+	c := newFakeClient(1100)
+	c.root = 1000
+
+	tests := []struct {
+		name      string
+		startTime time.Time
+		expected  uint64
+	}{
+		{"within history", genesisTime.Add(1050 * time.Second), 1049},
+		{"between blocks", genesisTime.Add(1050*time.Second + time.Millisecond), 1050},
+		{"at the spork root", genesisTime.Add(1000 * time.Second), 999},
+		{"before the spork root", genesisTime, 999},
+		{"latest block", genesisTime.Add(1100 * time.Second), 1099},
+		{"after the latest block", genesisTime.Add(2000 * time.Second), 1100},
+	}
+
+	for _, test := range tests {
+		p := newTestPoller(c)
+		p.StartTime = test.startTime
+
+		if height := start(t, p); height != test.expected {
+			t.Errorf("%s: expected the start header at height %d, got %d", test.name, test.expected, height)
+		}
+	}
+}
+
+func TestEventsWithMismatchedTypeDeliveredToQueriedType(t *testing.T) {
+	const returnedEvent = "A.0000000000000001.test.event"
+	const otherEvent = "A.0000000000000001.Test.Other"
+
+	c := newFakeClient(5)
+	c.addEvent(testEvent, 2)
+	c.addEvent(otherEvent, 3)
+
+	// the node returns events for testEvent queries with different casing, and includes an
+	// otherEvent in them
+	c.transform = func(query client.EventRangeQuery, blockEvents []client.BlockEvents) []client.BlockEvents {
+		if query.Type != testEvent {
+			return blockEvents
+		}
+
+		for i := range blockEvents {
+			for j := range blockEvents[i].Events {
+				blockEvents[i].Events[j].Type = returnedEvent
+			}
+			if blockEvents[i].Height == 3 {
+				blockEvents[i].Events = append(blockEvents[i].Events, flow.Event{Type: otherEvent, EventIndex: 1})
+			}
+		}
+		return blockEvents
+	}
+
+	for _, ordered := range []bool{false, true} {
+		p := newTestPoller(c)
+		p.OrderedDelivery = ordered
+		sub := p.Subscribe([]string{testEvent}, WithBufferSize(10))
+		otherSub := p.Subscribe([]string{otherEvent}, WithBufferSize(10))
+		poll(t, p, 0)
+
+		events := receive(sub)
+		if len(events) != 1 || events[0].BlockHeight != 2 {
+			t.Fatalf("expected an event at height 2 (ordered: %v), got %v", ordered, heights(events))
+		}
+		if events[0].Event.Type != returnedEvent {
+			t.Fatalf("expected the event to keep its returned type %s, got %s", returnedEvent, events[0].Event.Type)
+		}
+
+		// events of types that are queried on their own are only delivered once
+		if got := heights(receive(otherSub)); !reflect.DeepEqual(got, []uint64{3}) {
+			t.Fatalf("expected %s events at heights [3] (ordered: %v), got %v", otherEvent, ordered, got)
+		}
+	}
+}
+
+func TestMaxEventsPerCycleCountsDeliveredEvents(t *testing.T) {
+	c := newFakeClient(20)
+	for height := uint64(1); height <= 20; height++ {
+		c.addEvent(testEvent, height)
+		c.addEvent(testEvent, height)
+	}
+
+	p := newTestPoller(c)
+	p.MaxHeightRange = 10
+	p.MaxEventsPerCycle = 5
+
+	var ranges [][2]uint64
+	p.OnRangeProcessed = func(start, end uint64, eventCounts map[string]uint64) {
+		ranges = append(ranges, [2]uint64{start, end})
+		if expected := 2 * (end - start + 1); eventCounts[testEvent] != expected {
+			t.Errorf("expected %d events counted for heights %d-%d, got %d", expected, start, end, eventCounts[testEvent])
+		}
+	}
+
+	// events before the subscription's start height are polled, but not delivered
+	sub := p.Subscribe([]string{testEvent}, WithBufferSize(20), WithStartHeight(10))
+
+	if height := poll(t, p, 0); height != 12 {
+		t.Fatalf("expected the cycle to end at height 12, got %d", height)
+	}
+	if got := heights(receive(sub)); !reflect.DeepEqual(got, []uint64{10, 10, 11, 11, 12, 12}) {
+		t.Fatalf("expected events at heights [10 10 11 11 12 12], got %v", got)
+	}
+
+	if height := poll(t, p, 12); height != 15 {
+		t.Fatalf("expected the next cycle to end at height 15, got %d", height)
+	}
+	if got := heights(receive(sub)); !reflect.DeepEqual(got, []uint64{13, 13, 14, 14, 15, 15}) {
+		t.Fatalf("expected events at heights [13 13 14 14 15 15], got %v", got)
+	}
+
+	expected := [][2]uint64{{1, 10}, {11, 12}, {13, 15}}
+	if !reflect.DeepEqual(ranges, expected) {
+		t.Fatalf("expected ranges %v, got %v", expected, ranges)
+	}
+}
+
+func TestMaxBufferedEventsShrinksOrderedRange(t *testing.T) {
+	const otherEvent = "A.0000000000000001.Test.Other"
+
+	c := newFakeClient(10)
+	for height := uint64(1); height <= 10; height++ {
+		c.addEvent(testEvent, height)
+		c.addEvent(otherEvent, height)
+	}
+
+	p := newTestPoller(c)
+	p.MaxHeightRange = 10
+	p.OrderedDelivery = true
+	p.MaxBufferedEvents = 4
+
+	var ranges [][2]uint64
+	p.OnRangeProcessed = func(start, end uint64, eventCounts map[string]uint64) {
+		ranges = append(ranges, [2]uint64{start, end})
+	}
+
+	sub := p.Subscribe([]string{testEvent, otherEvent}, WithBufferSize(20))
+
+	if height := poll(t, p, 0); height != 10 {
+		t.Fatalf("expected to poll up to height 10, got %d", height)
+	}
+
+	expected := []uint64{1, 1, 2, 2, 3, 3, 4, 4, 5, 5, 6, 6, 7, 7, 8, 8, 9, 9, 10, 10}
+	if got := heights(receive(sub)); !reflect.DeepEqual(got, expected) {
+		t.Fatalf("expected events at heights %v, got %v", expected, got)
+	}
+
+	// the first type queried fills the buffer, so the second type is only queried up to the
+	// shrunk range, and the range ends once both types' events fit
+	if expected := [][2]uint64{{1, 2}, {3, 4}, {5, 6}, {7, 8}, {9, 10}}; !reflect.DeepEqual(ranges, expected) {
+		t.Fatalf("expected ranges %v, got %v", expected, ranges)
+	}
+
+	var queried [][2]uint64
+	for _, query := range c.eventQueries() {
+		queried = append(queried, [2]uint64{query.StartHeight, query.EndHeight})
+	}
+	expectedQueries := [][2]uint64{{1, 10}, {1, 4}, {3, 10}, {3, 6}, {5, 10}, {5, 8}, {7, 10}, {7, 10}, {9, 10}, {9, 10}}
+	if !reflect.DeepEqual(queried, expectedQueries) {
+		t.Fatalf("expected queries %v, got %v", expectedQueries, queried)
+	}
+}
+
+func TestLagExcludesConfirmationDepth(t *testing.T) {
+	c := newFakeClient(10)
+
+	p := newTestPoller(c)
+	p.ConfirmationDepth = 3
+	p.Subscribe([]string{testEvent})
+
+	if height := poll(t, p, 0); height != 7 {
+		t.Fatalf("expected to poll up to height 7, got %d", height)
+	}
+
+	ctx := context.Background()
+	if lag, err := p.Lag(ctx); err != nil || lag != 0 {
+		t.Fatalf("expected no lag once caught up, got %d (err: %v)", lag, err)
+	}
+
+	c.setLatest(12)
+	if lag, err := p.Lag(ctx); err != nil || lag != 2 {
+		t.Fatalf("expected a lag of 2 blocks, got %d (err: %v)", lag, err)
+	}
+}
@@ -0,0 +1,87 @@
+package poller
+
+import (
+	"context"
+
+	"github.com/onflow/flow-go-sdk"
+	"github.com/onflow/flow-go-sdk/client"
+	"golang.org/x/time/rate"
+	"google.golang.org/grpc"
+)
+
+// WithRateLimit limits requests to the access node to rps requests per second, with bursts of up
+// to burst requests. Waiting for the limiter is cancelled when the context is cancelled.
+//
+// The limiter waits inside any deadline set by options passed after it. Pass it after
+// WithRequestTimeout so time spent waiting for the limiter doesn't count against the request
+// timeout, and after WithTracerProvider to keep the wait out of the request spans.
+func WithRateLimit(rps float64, burst int) Option {
+	return func(p *EventPoller) {
+		p.client = &rateLimitedClient{
+			Client:  p.client,
+			limiter: rate.NewLimiter(rate.Limit(rps), burst),
+		}
+	}
+}
+
+// rateLimitedClient waits for the limiter before each request
+type rateLimitedClient struct {
+	Client
+	limiter *rate.Limiter
+}
+
+func (c *rateLimitedClient) GetLatestBlockHeader(ctx context.Context, isSealed bool, opts ...grpc.CallOption) (*flow.BlockHeader, error) {
+	if err := c.limiter.Wait(ctx); err != nil {
+		return nil, err
+	}
+	return c.Client.GetLatestBlockHeader(ctx, isSealed, opts...)
+}
+
+func (c *rateLimitedClient) GetBlockHeaderByHeight(ctx context.Context, height uint64, opts ...grpc.CallOption) (*flow.BlockHeader, error) {
+	if err := c.limiter.Wait(ctx); err != nil {
+		return nil, err
+	}
+	return c.Client.GetBlockHeaderByHeight(ctx, height, opts...)
+}
+
+func (c *rateLimitedClient) GetBlockHeaderByID(ctx context.Context, blockID flow.Identifier, opts ...grpc.CallOption) (*flow.BlockHeader, error) {
+	if err := c.limiter.Wait(ctx); err != nil {
+		return nil, err
+	}
+	return c.Client.GetBlockHeaderByID(ctx, blockID, opts...)
+}
+
+func (c *rateLimitedClient) GetEventsForHeightRange(ctx context.Context, query client.EventRangeQuery, opts ...grpc.CallOption) ([]client.BlockEvents, error) {
+	if err := c.limiter.Wait(ctx); err != nil {
+		return nil, err
+	}
+	return c.Client.GetEventsForHeightRange(ctx, query, opts...)
+}
+
+func (c *rateLimitedClient) GetAccountAtLatestBlock(ctx context.Context, address flow.Address, opts ...grpc.CallOption) (*flow.Account, error) {
+	if err := c.limiter.Wait(ctx); err != nil {
+		return nil, err
+	}
+	return c.Client.GetAccountAtLatestBlock(ctx, address, opts...)
+}
+
+func (c *rateLimitedClient) GetBlockByHeight(ctx context.Context, height uint64, opts ...grpc.CallOption) (*flow.Block, error) {
+	if err := c.limiter.Wait(ctx); err != nil {
+		return nil, err
+	}
+	return c.Client.GetBlockByHeight(ctx, height, opts...)
+}
+
+func (c *rateLimitedClient) GetCollection(ctx context.Context, colID flow.Identifier, opts ...grpc.CallOption) (*flow.Collection, error) {
+	if err := c.limiter.Wait(ctx); err != nil {
+		return nil, err
+	}
+	return c.Client.GetCollection(ctx, colID, opts...)
+}
+
+func (c *rateLimitedClient) GetTransactionResult(ctx context.Context, txID flow.Identifier, opts ...grpc.CallOption) (*flow.TransactionResult, error) {
+	if err := c.limiter.Wait(ctx); err != nil {
+		return nil, err
+	}
+	return c.Client.GetTransactionResult(ctx, txID, opts...)
+}
@@ -0,0 +1,75 @@
+package poller
+
+import (
+	"sync"
+)
+
+// WithRecentBuffer configures the poller to keep the last n delivered events in memory, so they can
+// be retrieved with Recent. This is useful to get recent context after reconnecting without
+// querying the chain again. It's disabled by default.
+func WithRecentBuffer(n int) Option {
+	return func(p *EventPoller) {
+		if n > 0 {
+			p.recent = newRecentBuffer(n)
+		}
+	}
+}
+
+// Recent returns up to the last n delivered events, oldest first. It returns nil unless the poller
+// was created with WithRecentBuffer.
+func (p *EventPoller) Recent(n int) []*BlockEvent {
+	if p.recent == nil {
+		return nil
+	}
+	return p.recent.last(n)
+}
+
+// recentBuffer is a fixed size ring buffer of delivered events
+type recentBuffer struct {
+	mu     sync.Mutex
+	events []*BlockEvent
+	next   int
+	full   bool
+}
+
+func newRecentBuffer(size int) *recentBuffer {
+	return &recentBuffer{
+		events: make([]*BlockEvent, size),
+	}
+}
+
+// add records an event, replacing the oldest event when the buffer is full
+func (b *recentBuffer) add(event *BlockEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.events[b.next] = event
+	b.next = (b.next + 1) % len(b.events)
+	if b.next == 0 {
+		b.full = true
+	}
+}
+
+// last returns up to the last n events, oldest first
+func (b *recentBuffer) last(n int) []*BlockEvent {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	count := b.next
+	if b.full {
+		count = len(b.events)
+	}
+	if n < count {
+		count = n
+	}
+	if count <= 0 {
+		return nil
+	}
+
+	events := make([]*BlockEvent, count)
+	for i := range events {
+		idx := (b.next - count + i + len(b.events)) % len(b.events)
+		events[i] = b.events[idx]
+	}
+	return events
+}
@@ -0,0 +1,154 @@
+package poller
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync/atomic"
+
+	"github.com/onflow/flow-go-sdk"
+)
+
+// maxReorgDepth is the number of heights below the last processed height for which block IDs are
+// tracked to detect reorgs
+const maxReorgDepth = 1000
+
+// Reorg notifies subscribers that blocks they received events for are no longer part of the chain.
+// Consumers should roll back any events they processed within the range. Events for the new blocks
+// in the range are delivered after the notification.
+type Reorg struct {
+	// StartHeight and EndHeight are the inclusive height range of the orphaned blocks
+	StartHeight uint64
+	EndHeight   uint64
+}
+
+// trackBlock records the ID of a processed block so reorgs can be detected. It's a no-op unless
+// FollowFinalized is enabled
+func (p *EventPoller) trackBlock(height uint64, id flow.Identifier) {
+	if !p.FollowFinalized {
+		return
+	}
+
+	p.blockIDsMu.Lock()
+	defer p.blockIDsMu.Unlock()
+
+	if p.blockIDs == nil {
+		p.blockIDs = make(map[uint64]flow.Identifier)
+	}
+	p.blockIDs[height] = id
+
+	for tracked := range p.blockIDs {
+		if tracked+maxReorgDepth < height {
+			delete(p.blockIDs, tracked)
+		}
+	}
+}
+
+// checkReorg verifies that the last processed block is still part of the chain. If it's not, it
+// finds the highest tracked block that is, notifies subscribers about the orphaned range, and
+// returns the header to resume polling from. This is best effort since only the IDs of blocks
+// that ended a polled range or contained events are tracked.
+func (p *EventPoller) checkReorg(ctx, deliverCtx context.Context, lastHeader *flow.BlockHeader) (*flow.BlockHeader, error) {
+	// only the height of the start header is known, so there's nothing to compare until a block
+	// has been processed
+	if lastHeader.ID == flow.EmptyID {
+		return lastHeader, nil
+	}
+
+	current, err := p.client.GetBlockHeaderByHeight(ctx, lastHeader.Height)
+	if err != nil {
+		return nil, fmt.Errorf("error getting header for height %d: %w", lastHeader.Height, err)
+	}
+
+	if current.ID == lastHeader.ID {
+		return lastHeader, nil
+	}
+
+	p.blockIDsMu.Lock()
+	tracked := make(map[uint64]flow.Identifier, len(p.blockIDs))
+	heights := make([]uint64, 0, len(p.blockIDs))
+	for height, id := range p.blockIDs {
+		if height < lastHeader.Height {
+			tracked[height] = id
+			heights = append(heights, height)
+		}
+	}
+	p.blockIDsMu.Unlock()
+
+	sort.Slice(heights, func(i, j int) bool { return heights[i] > heights[j] })
+
+	var forkHeader *flow.BlockHeader
+	for _, height := range heights {
+		header, err := p.client.GetBlockHeaderByHeight(ctx, height)
+		if err != nil {
+			return nil, fmt.Errorf("error getting header for height %d: %w", height, err)
+		}
+
+		if header.ID == tracked[height] {
+			forkHeader = header
+			break
+		}
+	}
+
+	// the fork is older than any tracked block, so resume from just before the oldest one
+	if forkHeader == nil {
+		height := lastHeader.Height - 1
+		if len(heights) > 0 {
+			height = heights[len(heights)-1] - 1
+		}
+
+		forkHeader, err = p.client.GetBlockHeaderByHeight(ctx, height)
+		if err != nil {
+			return nil, fmt.Errorf("error getting header for height %d: %w", height, err)
+		}
+	}
+
+	reorg := &Reorg{
+		StartHeight: forkHeader.Height + 1,
+		EndHeight:   lastHeader.Height,
+	}
+	p.log.Infof("detected reorg of heights %d - %d", reorg.StartHeight, reorg.EndHeight)
+
+	p.blockIDsMu.Lock()
+	for height := range p.blockIDs {
+		if height > forkHeader.Height {
+			delete(p.blockIDs, height)
+		}
+	}
+	p.blockIDsMu.Unlock()
+
+	if p.dedup != nil {
+		p.dedup.forget(reorg.StartHeight)
+	}
+
+	p.schedule.rewind(forkHeader.Height)
+
+	for _, sub := range p.allSubscribers() {
+		atomic.StoreUint64(&sub.watermark, forkHeader.Height)
+
+		if sub.Batches != nil {
+			if !p.deliverBatch(deliverCtx, sub, &BlockEventBatch{Reorg: reorg}) {
+				return nil, deliverCtx.Err()
+			}
+			continue
+		}
+
+		// client.BlockEvents can't represent a reorg
+		if sub.BlockEvents != nil {
+			continue
+		}
+
+		if sub.Transactions != nil {
+			if !p.deliverTransaction(deliverCtx, sub, &TransactionEvents{Reorg: reorg}) {
+				return nil, deliverCtx.Err()
+			}
+			continue
+		}
+
+		if !p.deliver(deliverCtx, sub, &BlockEvent{Reorg: reorg}) {
+			return nil, deliverCtx.Err()
+		}
+	}
+
+	return forkHeader, nil
+}
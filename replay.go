@@ -0,0 +1,206 @@
+package poller
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/onflow/flow-go-sdk"
+	"github.com/onflow/flow-go-sdk/client"
+)
+
+// Replay fetches all events of the given types within the height range from start to end,
+// inclusive, and calls handler with each event in block, transaction and event index order. It
+// doesn't use subscriptions, and can be called without Run. Replay returns when the range is done,
+// or with the first error returned by handler.
+func (p *EventPoller) Replay(ctx context.Context, events []string, start, end uint64, handler func(*BlockEvent) error) error {
+	if start > end {
+		return fmt.Errorf("invalid height range: start height %d is after end height %d", start, end)
+	}
+	events = p.resolveAliases(events)
+
+	return p.forEachChunk(start, end, func(chunkStart, chunkEnd uint64) error {
+		c := newBatchCollector(true)
+		for _, eventType := range uniqueEvents(events) {
+			blockEvents, err := p.getEvents(ctx, eventType, chunkStart, chunkEnd)
+			if err != nil {
+				return err
+			}
+			c.addBlockEvents(blockEvents)
+		}
+
+		for _, be := range c.orderedEvents() {
+			for _, event := range be.Events {
+				event := event
+				err := handler(&BlockEvent{
+					Event:            &event,
+					BlockHeight:      be.Height,
+					BlockTimestamp:   be.BlockTimestamp,
+					TransactionIndex: event.TransactionIndex,
+					EventIndex:       event.EventIndex,
+					payload:          newEventPayload(&event),
+				})
+				if err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// EventsAtHeight returns all events of the given types emitted in the block at height, in
+// transaction and event index order. Like Replay, it doesn't use subscriptions, and can be called
+// without Run.
+func (p *EventPoller) EventsAtHeight(ctx context.Context, height uint64, events []string) ([]*BlockEvent, error) {
+	var blockEvents []*BlockEvent
+	err := p.Replay(ctx, events, height, height, func(event *BlockEvent) error {
+		blockEvents = append(blockEvents, event)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return blockEvents, nil
+}
+
+// ReplayBlockIDs is like Replay, but takes the range as the IDs of its first and last blocks
+func (p *EventPoller) ReplayBlockIDs(ctx context.Context, events []string, startID, endID flow.Identifier, handler func(*BlockEvent) error) error {
+	start, err := p.client.GetBlockHeaderByID(ctx, startID)
+	if err != nil {
+		return fmt.Errorf("error getting start block %s: %w", startID, err)
+	}
+
+	end, err := p.client.GetBlockHeaderByID(ctx, endID)
+	if err != nil {
+		return fmt.Errorf("error getting end block %s: %w", endID, err)
+	}
+
+	return p.Replay(ctx, events, start.Height, end.Height, handler)
+}
+
+// forEachChunk calls fn for each chunk of up to MaxHeightRange blocks within the height range from
+// start to end, inclusive, stopping at the first error
+func (p *EventPoller) forEachChunk(start, end uint64, fn func(chunkStart, chunkEnd uint64) error) error {
+	// inverted ranges are left to fn to handle
+	if start > end {
+		return fn(start, end)
+	}
+
+	for chunkStart := start; ; {
+		chunkEnd := end
+		if end-chunkStart >= p.maxHeightRange() {
+			chunkEnd = chunkStart + p.maxHeightRange() - 1
+		}
+
+		if err := fn(chunkStart, chunkEnd); err != nil {
+			return err
+		}
+
+		if chunkEnd == end {
+			return nil
+		}
+		chunkStart = chunkEnd + 1
+	}
+}
+
+// getEvents queries events of a single type within a height range, retrying transient errors
+func (p *EventPoller) getEvents(ctx context.Context, eventType string, start, end uint64) ([]client.BlockEvents, error) {
+	blockEvents, err := p.queryEvents(ctx, eventType, start, end)
+	if err != nil {
+		return nil, fmt.Errorf("error getting %s events for heights %d-%d: %w", eventType, start, end, err)
+	}
+	return blockEvents, nil
+}
+
+// queryEvents queries events of a single type within a height range, retrying transient errors.
+// The results are sorted by height. Requests wait for a slot when MaxConcurrentRanges is set.
+//
+// When a response is larger than the max message size, the range is halved and queried again, down
+// to single blocks. The smaller range is used for later requests of the event type.
+func (p *EventPoller) queryEvents(ctx context.Context, eventType string, start, end uint64) ([]client.BlockEvents, error) {
+	if sem := p.rangeSemaphore(); sem != nil {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case sem <- struct{}{}:
+		}
+		defer func() { <-sem }()
+	}
+
+	var blockEvents []client.BlockEvents
+	for chunkStart := start; ; {
+		chunkEnd := end
+		if limit := p.rangeLimits.get(eventType); limit > 0 && end-chunkStart >= limit {
+			chunkEnd = chunkStart + limit - 1
+		}
+
+		chunk, err := p.requestEvents(ctx, eventType, chunkStart, chunkEnd)
+		if isMessageTooLarge(err) && chunkEnd > chunkStart {
+			limit := (chunkEnd - chunkStart + 1) / 2
+			p.log.Infof("response for %s events at heights %d-%d is too large, reducing range to %d blocks", eventType, chunkStart, chunkEnd, limit)
+			p.rangeLimits.shrink(eventType, limit)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		blockEvents = append(blockEvents, chunk...)
+
+		if chunkEnd == end {
+			return sortBlockEvents(blockEvents), nil
+		}
+		chunkStart = chunkEnd + 1
+	}
+}
+
+// requestEvents sends a single request for events of a type within a height range, retrying
+// transient errors
+func (p *EventPoller) requestEvents(ctx context.Context, eventType string, start, end uint64) ([]client.BlockEvents, error) {
+	var blockEvents []client.BlockEvents
+	err := p.withRetry(ctx, func() (err error) {
+		blockEvents, err = p.client.GetEventsForHeightRange(ctx, client.EventRangeQuery{
+			Type:        eventType,
+			StartHeight: start,
+			EndHeight:   end,
+		})
+		return err
+	})
+	return blockEvents, err
+}
+
+// rangeLimits tracks the reduced height ranges learned for event types whose responses were too
+// large for the max message size
+type rangeLimits struct {
+	mu     sync.Mutex
+	limits map[string]uint64
+}
+
+// get returns the max number of blocks to query at once for the event type, or 0 if there's no limit
+func (l *rangeLimits) get(eventType string) uint64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.limits[eventType]
+}
+
+// shrink limits the event type to ranges of at most size blocks
+func (l *rangeLimits) shrink(eventType string, size uint64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.limits == nil {
+		l.limits = make(map[string]uint64)
+	}
+	if current, ok := l.limits[eventType]; !ok || size < current {
+		l.limits[eventType] = size
+	}
+}
+
+func (p *EventPoller) rangeSemaphore() chan struct{} {
+	p.rangeSemOnce.Do(func() {
+		if p.MaxConcurrentRanges > 0 {
+			p.rangeSem = make(chan struct{}, p.MaxConcurrentRanges)
+		}
+	})
+	return p.rangeSem
+}
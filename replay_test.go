@@ -0,0 +1,38 @@
+package poller
+
+import (
+	"context"
+	"testing"
+)
+
+func TestEventsAtHeight(t *testing.T) {
+	const otherEvent = "A.0000000000000001.Test.Other"
+
+	c := newFakeClient(10)
+	c.addEvent(testEvent, 5)
+	c.addEvent(otherEvent, 5)
+	c.addEvent(testEvent, 5)
+	c.addEvent(testEvent, 6)
+
+	p := newTestPoller(c)
+	events, err := p.EventsAtHeight(context.Background(), 5, []string{testEvent, otherEvent})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := []string{testEvent, otherEvent, testEvent}
+	if len(events) != len(expected) {
+		t.Fatalf("expected %d events, got %d", len(expected), len(events))
+	}
+	for i, event := range events {
+		if event.BlockHeight != 5 || event.Event.Type != expected[i] || event.TransactionIndex != i {
+			t.Fatalf("unexpected event %d: %s", i, event)
+		}
+	}
+
+	for _, query := range c.eventQueries() {
+		if query.StartHeight != 5 || query.EndHeight != 5 {
+			t.Fatalf("expected queries for height 5 only, got %d-%d", query.StartHeight, query.EndHeight)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package poller
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"strings"
+	"time"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// DefaultRetryBackoff is the wait before the first retry of a failed request if RetryBackoff is
+// not set
+const DefaultRetryBackoff = 500 * time.Millisecond
+
+// withRetry calls fn, retrying up to MaxRetries times while it fails with a retryable error. The
+// wait between attempts starts at RetryBackoff and doubles after each attempt.
+func (p *EventPoller) withRetry(ctx context.Context, fn func() error) error {
+	backoff := p.RetryBackoff
+	if backoff <= 0 {
+		backoff = DefaultRetryBackoff
+	}
+
+	for attempt := 0; ; attempt++ {
+		err := fn()
+		if err == nil || attempt >= p.MaxRetries || !isRetryableError(err) {
+			return err
+		}
+
+		wait := backoff
+		if grpcCode(err) == codes.ResourceExhausted {
+			wait = rateLimitDelay(err, backoff)
+		}
+
+		p.log.Debugf("retrying request in %s after error (attempt %d of %d): %v", wait, attempt+1, p.MaxRetries, err)
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-p.clock.After(wait):
+		}
+		backoff *= 2
+	}
+}
+
+// rateLimitDelay returns the delay before retrying a request that was rate limited. Providers may
+// include a RetryInfo detail with the delay to use, otherwise the backoff is used with up to 50%
+// jitter added, so clients that were rate limited together don't retry together
+func rateLimitDelay(err error, backoff time.Duration) time.Duration {
+	var se interface{ GRPCStatus() *status.Status }
+	if errors.As(err, &se) {
+		for _, detail := range se.GRPCStatus().Details() {
+			if info, ok := detail.(*errdetails.RetryInfo); ok && info.GetRetryDelay() != nil {
+				if delay := info.GetRetryDelay().AsDuration(); delay > 0 {
+					return delay
+				}
+			}
+		}
+	}
+
+	return backoff + time.Duration(rand.Int63n(int64(backoff)/2+1))
+}
+
+// isRetryableError returns true if a failed request may succeed if sent again. Other errors, such
+// as InvalidArgument, will fail the same way every time.
+func isRetryableError(err error) bool {
+	// request timeouts may not be converted to a gRPC status
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+
+	switch grpcCode(err) {
+	case codes.Unavailable, codes.DeadlineExceeded:
+		return true
+	case codes.ResourceExhausted:
+		// responses over the max message size fail the same way each time
+		return !isMessageTooLarge(err)
+	}
+	return false
+}
+
+// isMessageTooLarge returns true if the request failed because the response was larger than the max
+// message size
+func isMessageTooLarge(err error) bool {
+	return err != nil && grpcCode(err) == codes.ResourceExhausted && strings.Contains(err.Error(), "larger than max")
+}
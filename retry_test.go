@@ -0,0 +1,82 @@
+package poller
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/onflow/flow-go-sdk/client"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+func TestRetryResourceExhaustedWithRetryInfo(t *testing.T) {
+	c := newFakeClient(10)
+	c.addEvent(testEvent, 5)
+
+	st, err := status.New(codes.ResourceExhausted, "rate limited").WithDetails(&errdetails.RetryInfo{
+		RetryDelay: durationpb.New(3 * time.Second),
+	})
+	if err != nil {
+		t.Fatalf("unexpected error adding status details: %v", err)
+	}
+
+	attempts := 0
+	c.onEvents = func(client.EventRangeQuery) error {
+		attempts++
+		if attempts == 1 {
+			return st.Err()
+		}
+		return nil
+	}
+
+	clock := &fakeClock{}
+	p := newTestPoller(c, WithClock(clock))
+	p.MaxRetries = 1
+
+	blockEvents, err := p.queryEvents(context.Background(), testEvent, 1, 10)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if attempts != 2 {
+		t.Fatalf("expected 2 attempts, got %d", attempts)
+	}
+	if len(blockEvents) != 10 || len(blockEvents[4].Events) != 1 {
+		t.Fatalf("expected the event at height 5 in the retried response, got %v", blockEvents)
+	}
+
+	waits := clock.recordedWaits()
+	if len(waits) != 1 || waits[0] != 3*time.Second {
+		t.Fatalf("expected a single wait of 3s from the RetryInfo detail, got %v", waits)
+	}
+}
+
+func TestRetryResourceExhaustedWithoutRetryInfo(t *testing.T) {
+	c := newFakeClient(10)
+
+	attempts := 0
+	c.onEvents = func(client.EventRangeQuery) error {
+		attempts++
+		if attempts == 1 {
+			return status.Error(codes.ResourceExhausted, "rate limited")
+		}
+		return nil
+	}
+
+	clock := &fakeClock{}
+	p := newTestPoller(c, WithClock(clock))
+	p.MaxRetries = 1
+	p.RetryBackoff = time.Second
+
+	if _, err := p.queryEvents(context.Background(), testEvent, 1, 10); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// the backoff is used with up to 50% jitter
+	waits := clock.recordedWaits()
+	if len(waits) != 1 || waits[0] < time.Second || waits[0] > 1500*time.Millisecond {
+		t.Fatalf("expected a single wait between 1s and 1.5s, got %v", waits)
+	}
+}
@@ -0,0 +1,176 @@
+package poller
+
+import (
+	"sync"
+	"time"
+)
+
+// WithInterval configures the subscription's event types to be queried at most once per interval,
+// instead of every polling cycle. This reduces requests for event types that are rarely emitted.
+// Events are still delivered for every block, but in larger ranges, and may be delivered after
+// events of other types from later blocks. An event type is queried every cycle if any of its
+// subscriptions doesn't set an interval, otherwise the shortest interval is used. Intervals are
+// ignored while a SubscribeAll subscription exists.
+func WithInterval(interval time.Duration) SubscribeOption {
+	return func(sub *Subscription) {
+		sub.interval = interval
+	}
+}
+
+// typeSchedule tracks the progress of an event type that's queried on its own interval, or has a
+// range that failed to poll
+type typeSchedule struct {
+	polledHeight uint64
+	nextPoll     time.Time
+
+	// failed is set when the blocks after polledHeight failed to poll, and are queried again with
+	// the next range
+	failed bool
+}
+
+// pollSchedule tracks event types queried on their own interval, and event types with a range that
+// failed to poll, which are queried again from the failed height with the next range
+type pollSchedule struct {
+	mu    sync.Mutex
+	types map[string]*typeSchedule
+}
+
+func newPollSchedule() *pollSchedule {
+	return &pollSchedule{
+		types: make(map[string]*typeSchedule),
+	}
+}
+
+// due returns whether the event type should be queried now, and the height to start from. A start
+// height of 0 means the type hasn't been queried yet, so it starts from the current range
+func (s *pollSchedule) due(eventType string, now time.Time) (bool, uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ts, ok := s.types[eventType]
+	if !ok {
+		return true, 0
+	}
+
+	return !now.Before(ts.nextPoll), addHeight(ts.polledHeight, 1)
+}
+
+// polled records that the event type was queried up to height
+func (s *pollSchedule) polled(eventType string, height uint64, next time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.types[eventType] = &typeSchedule{
+		polledHeight: height,
+		nextPoll:     next,
+	}
+}
+
+// failed records that the event type was only queried up to height, because the rest of the range
+// failed to poll. The rest is queried again with the next range
+func (s *pollSchedule) failed(eventType string, height uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.types[eventType] = &typeSchedule{
+		polledHeight: height,
+		failed:       true,
+	}
+}
+
+// failedHeight returns the height the event type was queried up to before a range failed to poll,
+// and whether it has a failed range
+func (s *pollSchedule) failedHeight(eventType string) (uint64, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ts, ok := s.types[eventType]
+	if !ok || !ts.failed {
+		return 0, false
+	}
+	return ts.polledHeight, true
+}
+
+// hasFailed returns true if any event type has a range that failed to poll
+func (s *pollSchedule) hasFailed() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, ts := range s.types {
+		if ts.failed {
+			return true
+		}
+	}
+	return false
+}
+
+// remove stops tracking an event type, e.g. once it's queried every cycle again, or a failed range
+// was queried again
+func (s *pollSchedule) remove(eventType string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.types, eventType)
+}
+
+// retain stops tracking event types that are not in eventTypes, e.g. once they're unsubscribed, so
+// their progress doesn't hold back the checkpoint
+func (s *pollSchedule) retain(eventTypes []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	keep := make(map[string]bool, len(eventTypes))
+	for _, eventType := range eventTypes {
+		keep[eventType] = true
+	}
+
+	for eventType := range s.types {
+		if !keep[eventType] {
+			delete(s.types, eventType)
+		}
+	}
+}
+
+// lowestHeight returns the lowest height all tracked event types have been queried up to, or
+// height if it's lower
+func (s *pollSchedule) lowestHeight(height uint64) uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, ts := range s.types {
+		if ts.polledHeight < height {
+			height = ts.polledHeight
+		}
+	}
+	return height
+}
+
+// rewind moves the progress of all tracked event types back to height, so blocks after it are
+// queried again
+func (s *pollSchedule) rewind(height uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, ts := range s.types {
+		if ts.polledHeight > height {
+			ts.polledHeight = height
+			ts.nextPoll = time.Time{}
+		}
+	}
+}
+
+// typeInterval returns the interval the event type is queried at, or 0 if it should be queried
+// every cycle
+func (p *EventPoller) typeInterval(eventType string) time.Duration {
+	var interval time.Duration
+	for _, sub := range p.subscribers(eventType) {
+		if sub.interval == 0 {
+			return 0
+		}
+
+		if interval == 0 || sub.interval < interval {
+			interval = sub.interval
+		}
+	}
+	return interval
+}
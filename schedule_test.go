@@ -0,0 +1,29 @@
+package poller
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestUnsubscribedIntervalTypeDoesNotHoldCheckpoint(t *testing.T) {
+	const otherEvent = "A.0000000000000001.Test.Other"
+
+	c := newFakeClient(10)
+	checkpointer := NewMemoryCheckpointer(0)
+	p := newTestPoller(c, WithCheckpointer(checkpointer))
+
+	sub := p.Subscribe([]string{testEvent}, WithInterval(time.Hour))
+	poll(t, p, 0)
+
+	sub.Cancel()
+	p.Subscribe([]string{otherEvent})
+
+	c.setLatest(20)
+	poll(t, p, 10)
+
+	saved, _ := checkpointer.Load(context.Background())
+	if saved != 20 {
+		t.Fatalf("expected checkpoint at height 20, got %d", saved)
+	}
+}
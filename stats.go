@@ -0,0 +1,80 @@
+package poller
+
+import (
+	"sync"
+	"time"
+)
+
+// Stats is a snapshot of the poller's internal counters
+type Stats struct {
+	// BlocksProcessed is the total number of blocks processed since the poller started
+	BlocksProcessed uint64
+
+	// EventsDelivered is the total number of events delivered to subscribers by event type
+	EventsDelivered map[string]uint64
+
+	// Subscriptions is the number of active subscriptions
+	Subscriptions int
+
+	// LastError is the last error encountered while polling, and LastErrorTime is when it happened.
+	// LastError is nil if no errors have been encountered
+	LastError     error
+	LastErrorTime time.Time
+
+	// Interval is the current polling interval
+	Interval time.Duration
+}
+
+// pollerStats accumulates the counters returned by Stats
+type pollerStats struct {
+	mu              sync.Mutex
+	blocksProcessed uint64
+	eventsDelivered map[string]uint64
+	lastError       error
+	lastErrorTime   time.Time
+}
+
+func (s *pollerStats) addBlocks(count uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.blocksProcessed += count
+}
+
+func (s *pollerStats) addEvents(eventType string, count int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.eventsDelivered == nil {
+		s.eventsDelivered = make(map[string]uint64)
+	}
+	s.eventsDelivered[eventType] += uint64(count)
+}
+
+func (s *pollerStats) setError(err error, now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.lastError = err
+	s.lastErrorTime = now
+}
+
+// Stats returns a snapshot of the poller's internal counters. It's safe to call while Run is active
+func (p *EventPoller) Stats() Stats {
+	p.stats.mu.Lock()
+	defer p.stats.mu.Unlock()
+
+	eventsDelivered := make(map[string]uint64, len(p.stats.eventsDelivered))
+	for eventType, count := range p.stats.eventsDelivered {
+		eventsDelivered[eventType] = count
+	}
+
+	return Stats{
+		BlocksProcessed: p.stats.blocksProcessed,
+		EventsDelivered: eventsDelivered,
+		Subscriptions:   len(p.allSubscribers()),
+		LastError:       p.stats.lastError,
+		LastErrorTime:   p.stats.lastErrorTime,
+		Interval:        p.pollInterval(),
+	}
+}
@@ -0,0 +1,603 @@
+package poller
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/onflow/flow-go-sdk"
+	"github.com/onflow/flow-go-sdk/client"
+)
+
+// bufferHighWaterRatio is how full a subscription's buffer must be for OnBufferHighWater to be
+// called
+const bufferHighWaterRatio = 0.8
+
+type DeliveryPolicy int
+
+const (
+	// DeliverBlock waits until the subscriber receives each event
+	DeliverBlock DeliveryPolicy = iota
+
+	// DeliverDrop skips sending events to subscribers whose channel is full
+	DeliverDrop
+
+	// DeliverReportDropped skips sending events to subscribers whose channel is full, and counts the
+	// dropped events on the subscription. See Subscription.Dropped
+	DeliverReportDropped
+)
+
+type Subscription struct {
+	ID      string
+	Channel chan *BlockEvent
+
+	// Batches receives events grouped by block for subscriptions created with SubscribeBatches.
+	// Channel is nil for batch subscriptions
+	Batches chan *BlockEventBatch
+
+	// Transactions receives events grouped by transaction for subscriptions created with
+	// SubscribeTransactions. Channel is nil for transaction subscriptions
+	Transactions chan *TransactionEvents
+
+	// BlockEvents receives events grouped by block for subscriptions created with
+	// SubscribeBlockEvents. Channel is nil for block events subscriptions
+	BlockEvents chan *client.BlockEvents
+
+	Events []string
+
+	poller      *EventPoller
+	errors      chan *SubscriptionError
+	bufferSize  int
+	filter      func(*flow.Event) bool
+	handler     func(context.Context, *BlockEvent) error
+	versions    map[string]heightRange
+	handlerMu   sync.Mutex
+	startHeight uint64
+	interval    time.Duration
+	dropped     uint64
+	highWater   uint32
+	watermark   uint64
+
+	// done is closed when the subscription is closed to unblock pending sends. mu guards closed so
+	// channels are never closed while a send is in progress
+	done      chan struct{}
+	closeOnce sync.Once
+	mu        sync.RWMutex
+	closed    bool
+}
+
+// StartHeight returns the first height the subscription receives events for. Subscriptions created
+// while Run is active start after the range being processed, so they only receive events for
+// complete blocks. Otherwise, it's the height set with WithStartHeight, or 0 if events are received
+// from the poller's start height
+func (s *Subscription) StartHeight() uint64 {
+	return s.startHeight
+}
+
+// Watermark returns the highest block height the poller has finished processing for the
+// subscription. All matching events up to and including this height have been sent to the
+// subscription's channel, including heights that contained no matching events. When a range fails
+// to poll for one of the subscription's event types, the watermark stays below it until the range
+// is queried again.
+func (s *Subscription) Watermark() uint64 {
+	return atomic.LoadUint64(&s.watermark)
+}
+
+// Errors returns a channel that receives errors that may have interrupted delivery to the
+// subscription. When the poller stops due to an error, a terminal error is sent before it exits.
+// Errors are dropped if they are not read, and the channel is closed when the subscription is closed
+func (s *Subscription) Errors() <-chan *SubscriptionError {
+	return s.errors
+}
+
+// Dropped returns the number of events that were not delivered to the subscription because its
+// channel was full. It is only tracked when the poller's DeliveryPolicy is DeliverReportDropped
+func (s *Subscription) Dropped() uint64 {
+	return atomic.LoadUint64(&s.dropped)
+}
+
+// SubscribeOption configures optional behavior of a Subscription
+type SubscribeOption func(*Subscription)
+
+// WithBufferSize sets the buffer size of the subscription's channel. A buffered channel allows the
+// poller to continue delivering events to other subscriptions while the consumer catches up. The
+// default of 0 creates an unbuffered channel
+func WithBufferSize(n int) SubscribeOption {
+	return func(sub *Subscription) {
+		sub.bufferSize = n
+	}
+}
+
+// WithFilter sets a predicate that events must match to be delivered to the subscription. Events
+// for which filter returns false are skipped. A nil filter delivers all events
+func WithFilter(filter func(*flow.Event) bool) SubscribeOption {
+	return func(sub *Subscription) {
+		sub.filter = filter
+	}
+}
+
+// WithStartHeight configures the subscription to only receive events from blocks at or after height.
+// If the subscription is created before Run is called, and height is lower than the poller's start
+// height, the poller starts from height instead so the subscription receives all events from
+// height. Other subscriptions still only receive events from the poller's configured start height.
+func WithStartHeight(height uint64) SubscribeOption {
+	return func(sub *Subscription) {
+		sub.startHeight = height
+	}
+}
+
+// Subscribe creates a subscription for a list of events, and returns a Subscription struct, which
+// contains a channel to receive events
+func (p *EventPoller) Subscribe(events []string, opts ...SubscribeOption) *Subscription {
+	sub := newSubscription(events, opts)
+	sub.Channel = make(chan *BlockEvent, sub.bufferSize)
+
+	return p.subscribe(sub)
+}
+
+// SubscribeContext creates a subscription like Subscribe, that's cancelled when ctx is done. This
+// ties the subscription's lifetime to the caller's, so it doesn't need to be unsubscribed manually
+func (p *EventPoller) SubscribeContext(ctx context.Context, events []string, opts ...SubscribeOption) *Subscription {
+	sub := p.Subscribe(events, opts...)
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			sub.Cancel()
+		case <-sub.done:
+			// the subscription was closed by the poller or cancelled
+		}
+	}()
+
+	return sub
+}
+
+// SubscribeEach creates a separate subscription for each event type, so each type can be handled
+// independently. The returned map is keyed by event type. Options are applied to every subscription
+func (p *EventPoller) SubscribeEach(events []string, opts ...SubscribeOption) map[string]*Subscription {
+	subs := make(map[string]*Subscription, len(events))
+	for _, event := range events {
+		if _, ok := subs[event]; ok {
+			continue
+		}
+		subs[event] = p.Subscribe([]string{event}, opts...)
+	}
+	return subs
+}
+
+func newSubscription(events []string, opts []SubscribeOption) *Subscription {
+	sub := &Subscription{
+		ID:     randomString(16),
+		Events: events,
+		errors: make(chan *SubscriptionError, errorBufferSize),
+		done:   make(chan struct{}),
+	}
+
+	for _, opt := range opts {
+		opt(sub)
+	}
+
+	return sub
+}
+
+func (p *EventPoller) subscribe(sub *Subscription) *Subscription {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	// the poller is closed, so the subscription will never receive events
+	if p.closed {
+		sub.close()
+		return sub
+	}
+
+	// a range may be partially processed, so subscriptions created while Run is active start with
+	// the next range to get events for complete blocks
+	if p.processingHeight > 0 && sub.startHeight <= p.processingHeight {
+		sub.startHeight = addHeight(p.processingHeight, 1)
+	}
+
+	// IDs are random, but make sure they're unique since Unsubscribe relies on them
+	for p.hasSubscriptionLocked(sub.ID) {
+		sub.ID = randomString(len(sub.ID))
+	}
+
+	sub.Events = p.resolveAliases(sub.Events)
+	for _, event := range sub.Events {
+		if event == allEvents {
+			continue
+		}
+		if err := ValidateEventType(event); err != nil {
+			if p.StrictEventTypes {
+				panic(err)
+			}
+			p.log.Errorf("subscription %s: %v", sub.ID, err)
+		}
+	}
+
+	sub.poller = p
+	sub.Events = uniqueEvents(sub.Events)
+	for _, event := range sub.Events {
+		p.subscriptions[event] = append(p.subscriptions[event], sub)
+	}
+
+	return sub
+}
+
+func (p *EventPoller) hasSubscriptions() bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	return len(p.subscriptions) > 0
+}
+
+func (p *EventPoller) hasSubscriptionLocked(id string) bool {
+	for _, subs := range p.subscriptions {
+		for _, sub := range subs {
+			if sub.ID == id {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Unsubscribe removes subscription for all provided events. Once the subscription is removed from
+// all of its events, it's closed, which also unblocks any pending send to it
+func (p *EventPoller) Unsubscribe(id string, events []string) {
+	events = p.resolveAliases(events)
+
+	p.mu.Lock()
+
+	var removed *Subscription
+	for _, event := range events {
+		if sub := p.unsubscribeLocked(id, event); sub != nil {
+			removed = sub
+		}
+	}
+
+	if removed != nil && p.hasSubscriptionLocked(id) {
+		removed = nil
+	}
+	p.mu.Unlock()
+
+	if removed != nil {
+		removed.close()
+	}
+}
+
+// Cancel removes the subscription from every event it was subscribed to, and closes its channels
+func (s *Subscription) Cancel() {
+	if s.poller != nil {
+		s.poller.mu.Lock()
+		for _, event := range s.Events {
+			s.poller.unsubscribeLocked(s.ID, event)
+		}
+		s.poller.mu.Unlock()
+	}
+
+	s.close()
+}
+
+// unsubscribeLocked removes the subscription with id from an event, and returns it if it was found.
+// The caller must hold the lock
+func (p *EventPoller) unsubscribeLocked(id string, event string) *Subscription {
+	if _, ok := p.subscriptions[event]; !ok {
+		return nil
+	}
+
+	var removed *Subscription
+	for i, sub := range p.subscriptions[event] {
+		if sub.ID == id {
+			removed = sub
+			p.subscriptions[event] = append(p.subscriptions[event][:i], p.subscriptions[event][i+1:]...)
+			break
+		}
+	}
+
+	if len(p.subscriptions[event]) == 0 {
+		delete(p.subscriptions, event)
+		delete(p.patternTypes, event)
+	}
+	return removed
+}
+
+// subscribedEvents returns a snapshot of the event types that currently have subscribers. Patterns
+// are replaced with the event types they matched when they were last expanded
+func (p *EventPoller) subscribedEvents() []string {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	seen := make(map[string]bool)
+	events := make([]string, 0, len(p.subscriptions))
+	for event := range p.subscriptions {
+		types := []string{event}
+		if isPattern(event) {
+			types = p.patternTypes[event]
+		}
+
+		for _, eventType := range types {
+			if !seen[eventType] {
+				seen[eventType] = true
+				events = append(events, eventType)
+			}
+		}
+	}
+	return events
+}
+
+// allSubscribers returns a snapshot of all registered subscriptions
+func (p *EventPoller) allSubscribers() []*Subscription {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	seen := make(map[*Subscription]bool)
+	var subs []*Subscription
+	for _, eventSubs := range p.subscriptions {
+		for _, sub := range eventSubs {
+			if !seen[sub] {
+				seen[sub] = true
+				subs = append(subs, sub)
+			}
+		}
+	}
+	return subs
+}
+
+// subscribers returns a snapshot of the subscriptions for an event type. The returned slice is
+// safe to use without holding the lock, so channel sends don't block Subscribe/Unsubscribe
+func (p *EventPoller) subscribers(eventType string) []*Subscription {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	subs := make([]*Subscription, len(p.subscriptions[eventType]))
+	copy(subs, p.subscriptions[eventType])
+
+	// a subscription may match the event both exactly and through patterns, but should only
+	// receive it once
+	seen := make(map[*Subscription]bool, len(subs))
+	for _, sub := range subs {
+		seen[sub] = true
+	}
+
+	for pattern := range p.patternTypes {
+		if !matchesPattern(pattern, eventType) {
+			continue
+		}
+
+		for _, sub := range p.subscriptions[pattern] {
+			if !seen[sub] {
+				seen[sub] = true
+				subs = append(subs, sub)
+			}
+		}
+	}
+
+	if eventType != allEvents {
+		for _, sub := range p.subscriptions[allEvents] {
+			if !seen[sub] {
+				seen[sub] = true
+				subs = append(subs, sub)
+			}
+		}
+	}
+	return subs
+}
+
+// uniqueEvents returns events with duplicates removed, preserving order
+func uniqueEvents(events []string) []string {
+	seen := make(map[string]bool, len(events))
+	unique := make([]string, 0, len(events))
+	for _, event := range events {
+		if !seen[event] {
+			seen[event] = true
+			unique = append(unique, event)
+		}
+	}
+	return unique
+}
+
+// close closes the subscription's channels. Any pending sends to the subscription are abandoned
+func (s *Subscription) close() {
+	s.closeOnce.Do(func() {
+		close(s.done)
+
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		s.closed = true
+		close(s.errors)
+		if s.Channel != nil {
+			close(s.Channel)
+		}
+		if s.Batches != nil {
+			close(s.Batches)
+		}
+		if s.Transactions != nil {
+			close(s.Transactions)
+		}
+		if s.BlockEvents != nil {
+			close(s.BlockEvents)
+		}
+	})
+}
+
+// randomString returns a random alphanumeric string of length n generated with crypto/rand, so IDs
+// are unpredictable and don't repeat across restarts
+func randomString(n int) string {
+	const letters = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+
+	// bytes at or above max are discarded so each letter is equally likely
+	const max = 256 - 256%len(letters)
+
+	s := make([]byte, 0, n)
+	buf := make([]byte, n)
+	for len(s) < n {
+		if _, err := rand.Read(buf); err != nil {
+			panic(fmt.Sprintf("error generating random string: %v", err))
+		}
+
+		for _, b := range buf {
+			if int(b) < max && len(s) < n {
+				s = append(s, letters[int(b)%len(letters)])
+			}
+		}
+	}
+
+	return string(s)
+}
+
+// deliver sends an event to the subscription's channel according to the DeliveryPolicy, or calls
+// its handler. It returns false if the context was cancelled before the event was delivered
+func (p *EventPoller) deliver(ctx context.Context, sub *Subscription, event *BlockEvent) bool {
+	// handlers are called without holding the subscription's lock, since they may cancel the
+	// subscription, which needs the write lock to close it
+	if sub.handler != nil {
+		return p.handle(ctx, sub, event)
+	}
+
+	sub.mu.RLock()
+	defer sub.mu.RUnlock()
+
+	if sub.closed {
+		event.ack.release()
+		return true
+	}
+
+	p.checkHighWater(sub, len(sub.Channel), cap(sub.Channel))
+
+	if p.DeliveryPolicy == DeliverBlock {
+		select {
+		case <-ctx.Done():
+			event.ack.release()
+			return false
+		case <-sub.done:
+			event.ack.release()
+			return true
+		case sub.Channel <- event:
+			return true
+		}
+	}
+
+	select {
+	case sub.Channel <- event:
+	default:
+		event.ack.release()
+		p.drop(sub)
+	}
+	return true
+}
+
+// deliverBatch sends a batch to the subscription's batch channel according to the DeliveryPolicy.
+// It returns false if the context was cancelled before the batch was delivered
+func (p *EventPoller) deliverBatch(ctx context.Context, sub *Subscription, batch *BlockEventBatch) bool {
+	sub.mu.RLock()
+	defer sub.mu.RUnlock()
+
+	if sub.closed {
+		return true
+	}
+
+	p.checkHighWater(sub, len(sub.Batches), cap(sub.Batches))
+
+	if p.DeliveryPolicy == DeliverBlock {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-sub.done:
+			return true
+		case sub.Batches <- batch:
+			return true
+		}
+	}
+
+	select {
+	case sub.Batches <- batch:
+	default:
+		p.drop(sub)
+	}
+	return true
+}
+
+func (p *EventPoller) deliverTransaction(ctx context.Context, sub *Subscription, tx *TransactionEvents) bool {
+	sub.mu.RLock()
+	defer sub.mu.RUnlock()
+
+	if sub.closed {
+		return true
+	}
+
+	p.checkHighWater(sub, len(sub.Transactions), cap(sub.Transactions))
+
+	if p.DeliveryPolicy == DeliverBlock {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-sub.done:
+			return true
+		case sub.Transactions <- tx:
+			return true
+		}
+	}
+
+	select {
+	case sub.Transactions <- tx:
+	default:
+		p.drop(sub)
+	}
+	return true
+}
+
+func (p *EventPoller) deliverBlockEvents(ctx context.Context, sub *Subscription, be *client.BlockEvents) bool {
+	sub.mu.RLock()
+	defer sub.mu.RUnlock()
+
+	if sub.closed {
+		return true
+	}
+
+	p.checkHighWater(sub, len(sub.BlockEvents), cap(sub.BlockEvents))
+
+	if p.DeliveryPolicy == DeliverBlock {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-sub.done:
+			return true
+		case sub.BlockEvents <- be:
+			return true
+		}
+	}
+
+	select {
+	case sub.BlockEvents <- be:
+	default:
+		p.drop(sub)
+	}
+	return true
+}
+
+// checkHighWater calls OnBufferHighWater when a subscription's buffer fills past
+// bufferHighWaterRatio. It's called once each time the buffer crosses the threshold
+func (p *EventPoller) checkHighWater(sub *Subscription, length, capacity int) {
+	if p.OnBufferHighWater == nil || capacity == 0 {
+		return
+	}
+
+	if float64(length) < bufferHighWaterRatio*float64(capacity) {
+		atomic.StoreUint32(&sub.highWater, 0)
+		return
+	}
+
+	if atomic.CompareAndSwapUint32(&sub.highWater, 0, 1) {
+		p.OnBufferHighWater(sub.ID, length, capacity)
+	}
+}
+
+func (p *EventPoller) drop(sub *Subscription) {
+	if p.DeliveryPolicy == DeliverReportDropped {
+		atomic.AddUint64(&sub.dropped, 1)
+	}
+}
@@ -0,0 +1,194 @@
+package poller
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/onflow/flow-go-sdk/client"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestDuplicateEventTypesDeliveredOnce(t *testing.T) {
+	c := newFakeClient(3)
+	c.addEvent(testEvent, 1)
+	c.addEvent(testEvent, 3)
+
+	p := newTestPoller(c)
+	sub := p.Subscribe([]string{testEvent, testEvent}, WithBufferSize(10))
+	poll(t, p, 0)
+
+	if got := heights(receive(sub)); !reflect.DeepEqual(got, []uint64{1, 3}) {
+		t.Fatalf("expected events at heights [1 3], got %v", got)
+	}
+	if queries := c.eventQueries(); len(queries) != 1 {
+		t.Fatalf("expected 1 event query, got %d: %v", len(queries), queries)
+	}
+}
+
+func TestRandomStringUnique(t *testing.T) {
+	seen := make(map[string]bool)
+	for i := 0; i < 10000; i++ {
+		s := randomString(16)
+		if len(s) != 16 {
+			t.Fatalf("expected a string of length 16, got %q", s)
+		}
+		if seen[s] {
+			t.Fatalf("duplicate random string %q after %d strings", s, i)
+		}
+		seen[s] = true
+	}
+}
+
+func TestConcurrentSubscribeUniqueIDs(t *testing.T) {
+	p := newTestPoller(newFakeClient(0))
+
+	const n = 100
+	subs := make([]*Subscription, n)
+
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			subs[i] = p.Subscribe([]string{testEvent})
+		}(i)
+	}
+	wg.Wait()
+
+	seen := make(map[string]bool)
+	for _, sub := range subs {
+		if seen[sub.ID] {
+			t.Fatalf("duplicate subscription ID %s", sub.ID)
+		}
+		seen[sub.ID] = true
+	}
+	if got := len(p.subscribers(testEvent)); got != n {
+		t.Fatalf("expected %d subscriptions, got %d", n, got)
+	}
+}
+
+// deliveries describes the events and markers received by a subscription, in order
+func deliveries(events []*BlockEvent) []string {
+	var got []string
+	for _, event := range events {
+		if event.Complete {
+			got = append(got, fmt.Sprintf("complete %d", event.BlockHeight))
+			continue
+		}
+		got = append(got, fmt.Sprintf("event %d", event.BlockHeight))
+	}
+	return got
+}
+
+func TestWatermarkWaitsForFailedRange(t *testing.T) {
+	c := newFakeClient(20)
+	c.addEvent(testEvent, 5)
+	c.addEvent(testEvent, 15)
+
+	// the first query for range 1-10 fails
+	failures := 1
+	c.onEvents = func(query client.EventRangeQuery) error {
+		if query.StartHeight == 1 && failures > 0 {
+			failures--
+			return status.Error(codes.Unavailable, "unavailable")
+		}
+		return nil
+	}
+
+	p := newTestPoller(c)
+	p.MaxHeightRange = 10
+	p.DeliverEmptyRanges = true
+	sub := p.Subscribe([]string{testEvent}, WithBufferSize(10))
+
+	if height := poll(t, p, 0); height != 20 {
+		t.Fatalf("expected to poll up to height 20, got %d", height)
+	}
+
+	// range 1-10 is queried again with range 11-20, and no marker is sent until it succeeds
+	expected := []string{"event 5", "event 15", "complete 20"}
+	if got := deliveries(receive(sub)); !reflect.DeepEqual(got, expected) {
+		t.Fatalf("expected deliveries %v, got %v", expected, got)
+	}
+	if sub.Watermark() != 20 {
+		t.Fatalf("expected watermark 20, got %d", sub.Watermark())
+	}
+}
+
+func TestFailedRangeQueriedAgainAtTip(t *testing.T) {
+	c := newFakeClient(20)
+	c.addEvent(testEvent, 5)
+	c.addEvent(testEvent, 15)
+
+	failing := true
+	c.onEvents = func(query client.EventRangeQuery) error {
+		if query.StartHeight == 1 && failing {
+			return status.Error(codes.Unavailable, "unavailable")
+		}
+		return nil
+	}
+
+	p := newTestPoller(c)
+	p.MaxHeightRange = 10
+	p.DeliverEmptyRanges = true
+	sub := p.Subscribe([]string{testEvent}, WithBufferSize(10))
+
+	poll(t, p, 0)
+
+	// range 11-20 isn't queried while range 1-10 is failing, so the watermark stays below both
+	if got := deliveries(receive(sub)); len(got) != 0 {
+		t.Fatalf("expected no deliveries while range 1-10 is failing, got %v", got)
+	}
+	if sub.Watermark() != 0 {
+		t.Fatalf("expected watermark 0 while range 1-10 is failing, got %d", sub.Watermark())
+	}
+
+	var last *SubscriptionError
+	for len(sub.Errors()) > 0 {
+		last = <-sub.Errors()
+	}
+	if last == nil || last.StartHeight != 1 || last.EndHeight != 20 {
+		t.Fatalf("expected the last error to cover heights 1-20, got %v", last)
+	}
+
+	// the failed range is queried again on the next cycle, even though there are no new blocks
+	failing = false
+	if height := poll(t, p, 20); height != 20 {
+		t.Fatalf("expected to stay at height 20, got %d", height)
+	}
+
+	expected := []string{"event 5", "event 15", "complete 20"}
+	if got := deliveries(receive(sub)); !reflect.DeepEqual(got, expected) {
+		t.Fatalf("expected deliveries %v, got %v", expected, got)
+	}
+	if sub.Watermark() != 20 {
+		t.Fatalf("expected watermark 20, got %d", sub.Watermark())
+	}
+}
+
+func TestSubscribeAfterIdlePolls(t *testing.T) {
+	c := newFakeClient(10)
+	c.addEvent(testEvent, 5)
+
+	checkpointer := NewMemoryCheckpointer(0)
+	p := newTestPoller(c, WithCheckpointer(checkpointer))
+
+	// the poller stays at the last processed height while there are no subscriptions
+	if height := poll(t, p, 0); height != 0 {
+		t.Fatalf("expected to stay at height 0 without subscriptions, got %d", height)
+	}
+	if saved, _ := checkpointer.Load(context.Background()); saved != 0 {
+		t.Fatalf("expected no checkpoint without subscriptions, got %d", saved)
+	}
+
+	sub := p.Subscribe([]string{testEvent}, WithBufferSize(10))
+	if height := poll(t, p, 0); height != 10 {
+		t.Fatalf("expected to poll up to height 10, got %d", height)
+	}
+	if got := heights(receive(sub)); !reflect.DeepEqual(got, []uint64{5}) {
+		t.Fatalf("expected events at heights [5], got %v", got)
+	}
+}
@@ -0,0 +1,83 @@
+package poller
+
+import (
+	"context"
+	"time"
+
+	"github.com/onflow/flow-go-sdk"
+	"github.com/onflow/flow-go-sdk/client"
+	"google.golang.org/grpc"
+)
+
+// WithRequestTimeout limits each request to the access node to timeout, so a node that stops
+// responding can't stall a polling cycle indefinitely. Requests that time out are retried according
+// to MaxRetries. The timeout covers all attempts of a request, so with multiple access nodes, a
+// request that times out is not retried on the next node right away. The node that timed out is
+// backed off though, so retries from MaxRetries go to the next node.
+//
+// Options that wrap the client apply in the order they're passed, so the timeout also covers the
+// options passed before it. For example, if WithRateLimit is passed first, the wait for the rate
+// limiter counts against the timeout, while passing it after WithRequestTimeout only times the
+// request itself.
+func WithRequestTimeout(timeout time.Duration) Option {
+	return func(p *EventPoller) {
+		p.client = &timeoutClient{
+			Client:  p.client,
+			timeout: timeout,
+		}
+	}
+}
+
+// timeoutClient sets a deadline on each request
+type timeoutClient struct {
+	Client
+	timeout time.Duration
+}
+
+func (c *timeoutClient) GetLatestBlockHeader(ctx context.Context, isSealed bool, opts ...grpc.CallOption) (*flow.BlockHeader, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+	return c.Client.GetLatestBlockHeader(ctx, isSealed, opts...)
+}
+
+func (c *timeoutClient) GetBlockHeaderByHeight(ctx context.Context, height uint64, opts ...grpc.CallOption) (*flow.BlockHeader, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+	return c.Client.GetBlockHeaderByHeight(ctx, height, opts...)
+}
+
+func (c *timeoutClient) GetBlockHeaderByID(ctx context.Context, blockID flow.Identifier, opts ...grpc.CallOption) (*flow.BlockHeader, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+	return c.Client.GetBlockHeaderByID(ctx, blockID, opts...)
+}
+
+func (c *timeoutClient) GetEventsForHeightRange(ctx context.Context, query client.EventRangeQuery, opts ...grpc.CallOption) ([]client.BlockEvents, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+	return c.Client.GetEventsForHeightRange(ctx, query, opts...)
+}
+
+func (c *timeoutClient) GetAccountAtLatestBlock(ctx context.Context, address flow.Address, opts ...grpc.CallOption) (*flow.Account, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+	return c.Client.GetAccountAtLatestBlock(ctx, address, opts...)
+}
+
+func (c *timeoutClient) GetBlockByHeight(ctx context.Context, height uint64, opts ...grpc.CallOption) (*flow.Block, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+	return c.Client.GetBlockByHeight(ctx, height, opts...)
+}
+
+func (c *timeoutClient) GetCollection(ctx context.Context, colID flow.Identifier, opts ...grpc.CallOption) (*flow.Collection, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+	return c.Client.GetCollection(ctx, colID, opts...)
+}
+
+func (c *timeoutClient) GetTransactionResult(ctx context.Context, txID flow.Identifier, opts ...grpc.CallOption) (*flow.TransactionResult, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+	return c.Client.GetTransactionResult(ctx, txID, opts...)
+}
@@ -0,0 +1,73 @@
+package poller
+
+import (
+	"context"
+
+	"github.com/onflow/flow-go-sdk"
+	"github.com/onflow/flow-go-sdk/client"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+)
+
+const tracerName = "github.com/peterargue/flow-event-poller"
+
+// WithTracerProvider configures the poller to start an OpenTelemetry span around each request for
+// the latest block header and events. Spans for event requests are tagged with the event type and
+// height range. When not set, no spans are created.
+//
+// Spans measure everything done by the options passed before WithTracerProvider, e.g. the wait for
+// WithRateLimit, while options passed after it, such as WithRequestTimeout, wrap the span itself.
+func WithTracerProvider(provider trace.TracerProvider) Option {
+	return func(p *EventPoller) {
+		p.client = &tracedClient{
+			Client: p.client,
+			tracer: provider.Tracer(tracerName),
+		}
+	}
+}
+
+// tracedClient starts a span for each traced request
+type tracedClient struct {
+	Client
+	tracer trace.Tracer
+}
+
+func (c *tracedClient) GetLatestBlockHeader(ctx context.Context, isSealed bool, opts ...grpc.CallOption) (*flow.BlockHeader, error) {
+	ctx, span := c.tracer.Start(ctx, "GetLatestBlockHeader", trace.WithAttributes(
+		attribute.Bool("flow.sealed", isSealed),
+	))
+	defer span.End()
+
+	header, err := c.Client.GetLatestBlockHeader(ctx, isSealed, opts...)
+	if err != nil {
+		recordError(span, err)
+		return nil, err
+	}
+
+	span.SetAttributes(attribute.Int64("flow.height", int64(header.Height)))
+	return header, nil
+}
+
+func (c *tracedClient) GetEventsForHeightRange(ctx context.Context, query client.EventRangeQuery, opts ...grpc.CallOption) ([]client.BlockEvents, error) {
+	ctx, span := c.tracer.Start(ctx, "GetEventsForHeightRange", trace.WithAttributes(
+		attribute.String("flow.event_type", query.Type),
+		attribute.Int64("flow.start_height", int64(query.StartHeight)),
+		attribute.Int64("flow.end_height", int64(query.EndHeight)),
+	))
+	defer span.End()
+
+	blockEvents, err := c.Client.GetEventsForHeightRange(ctx, query, opts...)
+	if err != nil {
+		recordError(span, err)
+		return nil, err
+	}
+
+	return blockEvents, nil
+}
+
+func recordError(span trace.Span, err error) {
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+}
@@ -0,0 +1,52 @@
+package poller
+
+import (
+	"time"
+
+	"github.com/onflow/flow-go-sdk"
+)
+
+// TransactionEvents contains all events emitted by a single transaction that match a subscription
+type TransactionEvents struct {
+	TransactionID  flow.Identifier
+	BlockID        flow.Identifier
+	BlockHeight    uint64
+	BlockTimestamp time.Time
+
+	// Events are ordered by event index
+	Events []flow.Event
+
+	// Reorg is set when this is a reorg notification instead of a transaction's events. All other
+	// fields are empty for reorg notifications
+	Reorg *Reorg
+}
+
+// SubscribeTransactions creates a subscription for a list of events that delivers all matching
+// events emitted by a transaction at once. Transactions are sent on the Subscription's Transactions
+// channel, in block and transaction order.
+func (p *EventPoller) SubscribeTransactions(events []string, opts ...SubscribeOption) *Subscription {
+	sub := newSubscription(events, opts)
+	sub.Transactions = make(chan *TransactionEvents, sub.bufferSize)
+
+	return p.subscribe(sub)
+}
+
+// splitTransactions groups a batch's events by transaction. The batch's events must already be
+// sorted by transaction index
+func splitTransactions(batch *BlockEventBatch) []*TransactionEvents {
+	var txs []*TransactionEvents
+	for _, event := range batch.Events {
+		if len(txs) == 0 || txs[len(txs)-1].TransactionID != event.TransactionID {
+			txs = append(txs, &TransactionEvents{
+				TransactionID:  event.TransactionID,
+				BlockID:        batch.BlockID,
+				BlockHeight:    batch.BlockHeight,
+				BlockTimestamp: batch.BlockTimestamp,
+			})
+		}
+
+		tx := txs[len(txs)-1]
+		tx.Events = append(tx.Events, event)
+	}
+	return txs
+}
@@ -0,0 +1,91 @@
+package poller
+
+import (
+	"math"
+)
+
+// VersionedType is an event type that's valid within a height range, used to follow an event across
+// contract migrations that change its type
+type VersionedType struct {
+	Type string
+
+	// FromHeight and ToHeight are the inclusive height range the type is used in. ToHeight is 0
+	// when the type is still in use
+	FromHeight uint64
+	ToHeight   uint64
+}
+
+// SubscribeVersioned creates a subscription for a single logical event whose type changed over time,
+// e.g. when its contract was redeployed at a new address. Each type is only queried and delivered
+// within its height range, so the subscription receives the events of whichever type was in use at
+// each height. Each type should only be listed once.
+func (p *EventPoller) SubscribeVersioned(types []VersionedType, opts ...SubscribeOption) *Subscription {
+	events := make([]string, 0, len(types))
+	versions := make(map[string]heightRange, len(types))
+	for _, t := range types {
+		// versions are looked up by the full type events are delivered with
+		eventType := p.resolveAliases([]string{t.Type})[0]
+		events = append(events, eventType)
+
+		to := t.ToHeight
+		if to == 0 {
+			to = math.MaxUint64
+		}
+		versions[eventType] = heightRange{from: t.FromHeight, to: to}
+	}
+
+	sub := newSubscription(events, opts)
+	sub.Channel = make(chan *BlockEvent, sub.bufferSize)
+	sub.versions = versions
+
+	return p.subscribe(sub)
+}
+
+// heightRange is an inclusive range of heights
+type heightRange struct {
+	from uint64
+	to   uint64
+}
+
+// inHeightRange returns true if the subscription receives events of the type at height
+func (s *Subscription) inHeightRange(eventType string, height uint64) bool {
+	r, ok := s.versions[eventType]
+	return !ok || (height >= r.from && height <= r.to)
+}
+
+// typeHeights returns the part of the height range from start to end that any subscriber receives
+// events of the type for, and false if there is none. Inverted ranges are returned as is
+func (p *EventPoller) typeHeights(eventType string, start, end uint64) (uint64, uint64, bool) {
+	if start > end {
+		return start, end, true
+	}
+
+	var from, to uint64
+	first := true
+	for _, sub := range p.subscribers(eventType) {
+		r, ok := sub.versions[eventType]
+		if !ok {
+			return start, end, true
+		}
+
+		if first || r.from < from {
+			from = r.from
+		}
+		if first || r.to > to {
+			to = r.to
+		}
+		first = false
+	}
+
+	if first {
+		return start, end, true
+	}
+
+	if from > start {
+		start = from
+	}
+	if to < end {
+		end = to
+	}
+	return start, end, start <= end
+}